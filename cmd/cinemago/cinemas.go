@@ -0,0 +1,333 @@
+package main
+
+import (
+	"cinemaGo/internal/models"
+	"cinemaGo/pkg/validator"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+func (app *application) createCinemaHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name    string `json:"name"`
+		Address string `json:"address"`
+		City    string `json:"city"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	cinema := &models.Cinema{
+		Name:    input.Name,
+		Address: input.Address,
+		City:    input.City,
+	}
+	v := validator.New()
+	if models.ValidateCinema(v, cinema); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Cinemas.Insert(cinema)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/cinemas/%d", cinema.ID))
+	err = app.writeJSON(w, http.StatusCreated, envelope{"cinema": cinema}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The readCinemaParam() helper looks up the cinema identified by the "id" URL parameter.
+// If the parameter is invalid or the cinema doesn't exist, it sends a 404 Not Found
+// response and returns false.
+func (app *application) readCinemaParam(w http.ResponseWriter, r *http.Request) (*models.Cinema, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+	cinema, err := app.models.Cinemas.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	return cinema, true
+}
+
+func (app *application) showCinemaHandler(w http.ResponseWriter, r *http.Request) {
+	cinema, ok := app.readCinemaParam(w, r)
+	if !ok {
+		return
+	}
+	err := app.writeJSON(w, http.StatusOK, envelope{"cinema": cinema}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateCinemaHandler(w http.ResponseWriter, r *http.Request) {
+	cinema, ok := app.readCinemaParam(w, r)
+	if !ok {
+		return
+	}
+	var input struct {
+		Name    *string `json:"name"`
+		Address *string `json:"address"`
+		City    *string `json:"city"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if input.Name != nil {
+		cinema.Name = *input.Name
+	}
+	if input.Address != nil {
+		cinema.Address = *input.Address
+	}
+	if input.City != nil {
+		cinema.City = *input.City
+	}
+	v := validator.New()
+	if models.ValidateCinema(v, cinema); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Cinemas.Update(cinema)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"cinema": cinema}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteCinemaHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	err = app.models.Cinemas.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "cinema successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listCinemasHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name string
+		City string
+		models.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+	input.Name = app.readString(qs, "name", "")
+	input.City = app.readString(qs, "city", "")
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "name", "city", "-id", "-name", "-city"}
+	if models.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	cinemas, metadata, err := app.models.Cinemas.GetAll(input.Name, input.City, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"cinemas": cinemas, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// List the halls in a cinema, with their seating layouts.
+func (app *application) listCinemaHallsHandler(w http.ResponseWriter, r *http.Request) {
+	cinema, ok := app.readCinemaParam(w, r)
+	if !ok {
+		return
+	}
+	halls, err := app.models.Cinemas.GetAllHalls(cinema.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"halls": halls}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createCinemaHallHandler(w http.ResponseWriter, r *http.Request) {
+	cinema, ok := app.readCinemaParam(w, r)
+	if !ok {
+		return
+	}
+	var input struct {
+		Name   string               `json:"name"`
+		Layout models.SeatingLayout `json:"layout"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	hall := &models.Hall{
+		CinemaID: cinema.ID,
+		Name:     input.Name,
+		Layout:   input.Layout,
+	}
+	v := validator.New()
+	if models.ValidateHall(v, hall); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Cinemas.InsertHall(hall)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrDuplicateHall):
+			v.AddError("name", "a hall with this name already exists in the cinema")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/halls/%d", hall.ID))
+	err = app.writeJSON(w, http.StatusCreated, envelope{"hall": hall}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The readHallParam() helper looks up the hall identified by the "id" URL parameter.
+// If the parameter is invalid or the hall doesn't exist, it sends a 404 Not Found
+// response and returns false.
+func (app *application) readHallParam(w http.ResponseWriter, r *http.Request) (*models.Hall, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+	hall, err := app.models.Cinemas.GetHall(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	return hall, true
+}
+
+func (app *application) showHallHandler(w http.ResponseWriter, r *http.Request) {
+	hall, ok := app.readHallParam(w, r)
+	if !ok {
+		return
+	}
+	err := app.writeJSON(w, http.StatusOK, envelope{"hall": hall}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Rename a hall or replace its seating layout. The layout is always replaced as a
+// whole.
+func (app *application) updateHallHandler(w http.ResponseWriter, r *http.Request) {
+	hall, ok := app.readHallParam(w, r)
+	if !ok {
+		return
+	}
+	var input struct {
+		Name   *string               `json:"name"`
+		Layout *models.SeatingLayout `json:"layout"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if input.Name != nil {
+		hall.Name = *input.Name
+	}
+	if input.Layout != nil {
+		hall.Layout = *input.Layout
+	}
+	v := validator.New()
+	if models.ValidateHall(v, hall); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Cinemas.UpdateHall(hall)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, models.ErrDuplicateHall):
+			v.AddError("name", "a hall with this name already exists in the cinema")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"hall": hall}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteHallHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	err = app.models.Cinemas.DeleteHall(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "hall successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"cinemaGo/internal/models"
+	"cinemaGo/pkg/validator"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// List the discussion of a movie as threads. Pagination applies to the top-level
+// comments, and each one is returned with all of its replies.
+func (app *application) listMovieCommentsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	var input struct {
+		models.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-created_at")
+	input.Filters.SortSafelist = []string{"created_at", "-created_at"}
+	if models.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	_, err = app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	comments, metadata, err := app.models.Comments.GetThreadsForMovie(id, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"comments": comments, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Post a comment on a movie, or a reply to another comment if a parent_id is given.
+func (app *application) createMovieCommentHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	_, err = app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	var input struct {
+		Body     string `json:"body"`
+		ParentID *int64 `json:"parent_id"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	comment := &models.Comment{
+		MovieID:  id,
+		UserID:   app.contextGetUser(r).ID,
+		ParentID: input.ParentID,
+		Body:     input.Body,
+	}
+	v := validator.New()
+	if input.ParentID != nil {
+		parent, err := app.models.Comments.Get(*input.ParentID)
+		if err != nil && !errors.Is(err, models.ErrRecordNotFound) {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		switch {
+		case parent == nil || parent.MovieID != id:
+			v.AddError("parent_id", "must be the ID of a comment on this movie")
+		case parent.IsDeleted() || parent.IsHidden():
+			v.AddError("parent_id", "can't reply to a deleted or hidden comment")
+		default:
+			comment.Depth = parent.Depth + 1
+		}
+	}
+	if models.ValidateComment(v, comment); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Comments.Insert(comment)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/comments/%d", comment.ID))
+	err = app.writeJSON(w, http.StatusCreated, envelope{"comment": comment}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The readComment() helper fetches the comment named in the URL. If it doesn't exist,
+// it sends a 404 Not Found response and returns nil.
+func (app *application) readComment(w http.ResponseWriter, r *http.Request) *models.Comment {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil
+	}
+	comment, err := app.models.Comments.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+	return comment
+}
+
+// Show a single comment, without its replies. Deleted and hidden comments are redacted,
+// just as they are in a thread.
+func (app *application) showCommentHandler(w http.ResponseWriter, r *http.Request) {
+	comment := app.readComment(w, r)
+	if comment == nil {
+		return
+	}
+	comment.Redact()
+	err := app.writeJSON(w, http.StatusOK, envelope{"comment": comment}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Edit one of the current user's comments. This is only allowed for a short time after
+// the comment was posted, and not once it has been deleted or hidden.
+func (app *application) updateCommentHandler(w http.ResponseWriter, r *http.Request) {
+	comment := app.readComment(w, r)
+	if comment == nil {
+		return
+	}
+	if comment.UserID != app.contextGetUser(r).ID {
+		app.notPermittedResponse(w, r)
+		return
+	}
+	var input struct {
+		Body *string `json:"body"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if !comment.Editable(time.Now()) {
+		v.AddError("body", fmt.Sprintf("comments can only be edited for %s after posting, and not once deleted or hidden", models.CommentEditWindow))
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if input.Body != nil {
+		comment.Body = *input.Body
+	}
+	if models.ValidateComment(v, comment); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Comments.Update(comment)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"comment": comment}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Delete one of the current user's comments. The comment stays in its thread as a
+// placeholder, so that any replies to it still make sense.
+func (app *application) deleteCommentHandler(w http.ResponseWriter, r *http.Request) {
+	comment := app.readComment(w, r)
+	if comment == nil {
+		return
+	}
+	if comment.UserID != app.contextGetUser(r).ID {
+		app.notPermittedResponse(w, r)
+		return
+	}
+	err := app.models.Comments.Delete(comment.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "comment successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Report a comment to the moderators.
+func (app *application) createCommentReportHandler(w http.ResponseWriter, r *http.Request) {
+	comment := app.readComment(w, r)
+	if comment == nil {
+		return
+	}
+	if comment.IsDeleted() {
+		app.notFoundResponse(w, r)
+		return
+	}
+	var input struct {
+		Reason string `json:"reason"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	user := app.contextGetUser(r)
+	flag := &models.CommentFlag{
+		CommentID: comment.ID,
+		UserID:    &user.ID,
+		Reason:    input.Reason,
+	}
+	v := validator.New()
+	if models.ValidateCommentFlag(v, flag); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Comments.InsertFlag(flag)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrDuplicateReport):
+			v.AddError("comment", "you have already reported this comment")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusCreated, envelope{"report": flag}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// List the comments waiting for a moderator, with the reasons they were flagged. By
+// default the comments which have been waiting longest come first.
+func (app *application) listModerationQueueHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		models.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "flagged_at")
+	input.Filters.SortSafelist = []string{"flagged_at", "flag_count", "-flagged_at", "-flag_count"}
+	if models.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	queue, metadata, err := app.models.Comments.GetModerationQueue(input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"comments": queue, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Show a comment, unredacted, along with the history of moderation actions on it.
+func (app *application) showCommentModerationHandler(w http.ResponseWriter, r *http.Request) {
+	comment := app.readComment(w, r)
+	if comment == nil {
+		return
+	}
+	actions, err := app.models.Comments.GetModerationActions(comment.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"comment": comment, "actions": actions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Hide a comment from other users. This resolves any flags on the comment.
+func (app *application) hideCommentHandler(w http.ResponseWriter, r *http.Request) {
+	app.moderateComment(w, r, models.CommentActionHide)
+}
+
+// Make a hidden comment visible again. For a comment which isn't hidden, this
+// dismisses the flags on it instead.
+func (app *application) restoreCommentHandler(w http.ResponseWriter, r *http.Request) {
+	app.moderateComment(w, r, models.CommentActionRestore)
+}
+
+func (app *application) moderateComment(w http.ResponseWriter, r *http.Request, action string) {
+	comment := app.readComment(w, r)
+	if comment == nil {
+		return
+	}
+	var input struct {
+		Reason string `json:"reason"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	v.Check(len(input.Reason) <= 500, "reason", "must not be more than 500 bytes long")
+	v.Check(!comment.IsDeleted(), "comment", "has been deleted by its author")
+	if action == models.CommentActionHide {
+		v.Check(!comment.IsHidden(), "comment", "is already hidden")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	moderatorID := app.contextGetUser(r).ID
+	if action == models.CommentActionHide {
+		err = app.models.Comments.Hide(comment, moderatorID, input.Reason)
+	} else {
+		err = app.models.Comments.Restore(comment, moderatorID, input.Reason)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"comment": comment}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -14,6 +14,15 @@ type contextKey string
 // in the request context.
 const userContextKey = contextKey("user")
 
+// The tokenContextKey constant is used for the plaintext authentication token that was
+// presented in the Authorization header of the current request.
+const tokenContextKey = contextKey("token")
+
+// The scopesContextKey constant is used for the permission codes that the credential
+// used for the current request is limited to, if it is an API key or a token issued to
+// a third-party OAuth client.
+const scopesContextKey = contextKey("scopes")
+
 // The contextSetUser() method returns a new copy of the request with the provided
 // User struct added to the context. Note that we use our userContextKey constant as the
 // key.
@@ -33,3 +42,37 @@ func (app *application) contextGetUser(r *http.Request) *models.User {
 	}
 	return user
 }
+
+// The contextSetToken() method returns a new copy of the request with the plaintext
+// authentication token added to the context.
+func (app *application) contextSetToken(r *http.Request, token string) *http.Request {
+	ctx := context.WithValue(r.Context(), tokenContextKey, token)
+	return r.WithContext(ctx)
+}
+
+// The contextGetToken() method retrieves the plaintext authentication token from the
+// request context. Like contextGetUser() we only call this when we logically expect
+// the token to be there (i.e. for an authenticated user), so we panic if it isn't.
+func (app *application) contextGetToken(r *http.Request) string {
+	token, ok := r.Context().Value(tokenContextKey).(string)
+	if !ok {
+		panic("missing token value in request context")
+	}
+	return token
+}
+
+// The contextSetScopes() method returns a new copy of the request with the permission
+// codes that its credential is limited to added to the context.
+func (app *application) contextSetScopes(r *http.Request, scopes models.Permissions) *http.Request {
+	ctx := context.WithValue(r.Context(), scopesContextKey, scopes)
+	return r.WithContext(ctx)
+}
+
+// The contextGetScopes() method retrieves the permission codes that the current
+// credential is limited to. Unlike the other helpers it doesn't panic if there aren't
+// any, because most requests are made by the user directly; the second return value is
+// false in that case.
+func (app *application) contextGetScopes(r *http.Request) (models.Permissions, bool) {
+	scopes, ok := r.Context().Value(scopesContextKey).(models.Permissions)
+	return scopes, ok
+}
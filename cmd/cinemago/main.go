@@ -4,9 +4,16 @@ import (
 	"cinemaGo/internal/delivery/jsonlog"
 	"cinemaGo/internal/delivery/mailer"
 	"cinemaGo/internal/models"
+	"cinemaGo/pkg/jwt"
+	"cinemaGo/pkg/oidc"
+	"cinemaGo/pkg/wordfilter"
 	"context"      // New import
 	"database/sql" // New import
+	"errors"
+	"expvar"
 	"flag"
+	"fmt"
+	"net/http"
 	"os"
 	"time"
 
@@ -35,8 +42,43 @@ func main() {
 	flag.StringVar(&cfg.Smtp.Username, "smtp-username", "f4750b21555b82", "SMTP username")
 	flag.StringVar(&cfg.Smtp.Password, "smtp-password", "2a633828490fd6", "SMTP password")
 	flag.StringVar(&cfg.Smtp.Sender, "smtp-sender", "CinemaGo <no-reply@cinmemago.net>", "SMTP sender")
+	// Read the permissions cache TTL. Setting this to 0 disables the cache.
+	flag.DurationVar(&cfg.Permissions.CacheTTL, "permissions-cache-ttl", time.Minute, "How long to cache user permissions for (0 to disable)")
+	// Read the lifetimes of authentication tokens and the refresh tokens used to renew
+	// them.
+	flag.DurationVar(&cfg.Tokens.AccessTTL, "access-token-ttl", 15*time.Minute, "Authentication token lifetime")
+	flag.DurationVar(&cfg.Tokens.RefreshTTL, "refresh-token-ttl", 30*24*time.Hour, "Refresh token lifetime")
+	// Choose between opaque and JWT authentication tokens. The -jwt-key flag can be
+	// given more than once, so that tokens signed with an old key are still accepted
+	// after switching -jwt-signing-key to a new one.
+	flag.StringVar(&cfg.Tokens.Format, "token-format", models.TokenFormatOpaque, "Authentication token format (opaque|jwt)")
+	flag.Func("jwt-key", "JWT key as id:algorithm:base64-key, where algorithm is HS256 or EdDSA (may be repeated)", func(s string) error {
+		cfg.Tokens.Keys = append(cfg.Tokens.Keys, s)
+		return nil
+	})
+	flag.StringVar(&cfg.Tokens.SigningKey, "jwt-signing-key", "", "ID of the JWT key used to sign new tokens (defaults to the first key)")
+	// Read the settings for signing in with an external OpenID Connect identity
+	// provider. Leave -oidc-issuer empty to disable it.
+	flag.StringVar(&cfg.Oidc.Issuer, "oidc-issuer", "", "OpenID Connect issuer URL")
+	flag.StringVar(&cfg.Oidc.ClientID, "oidc-client-id", "", "OpenID Connect client ID")
+	flag.StringVar(&cfg.Oidc.ClientSecret, "oidc-client-secret", os.Getenv("CINEMAGO_OIDC_CLIENT_SECRET"), "OpenID Connect client secret")
+	flag.StringVar(&cfg.Oidc.RedirectURI, "oidc-redirect-uri", "", "OpenID Connect redirect URI")
+	// Read the path of the word list used to flag comments for moderation.
+	flag.StringVar(&cfg.Comments.WordList, "comment-wordlist", "", "File of words which flag comments for moderation, one per line")
 	flag.Parse()
 	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
+	jwtKeys, err := openJWTKeys(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+	oidcProvider, err := openOIDCProvider(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+	commentFilter, err := openCommentFilter(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
 	db, err := openDB(cfg)
 	if err != nil {
 		logger.PrintFatal(err, nil)
@@ -48,9 +90,31 @@ func main() {
 	app := &models.Application{
 		Config: cfg,
 		Logger: logger,
-		Models: models.NewModels(db),
+		Models: models.NewModels(db, cfg.Permissions.CacheTTL, jwtKeys, oidcProvider, commentFilter),
 		Mailer: mailer.New(cfg.Smtp.Host, cfg.Smtp.Port, cfg.Smtp.Username, cfg.Smtp.Password, cfg.Smtp.Sender),
 	}
+	// Publish the permission cache hit/miss counters, so that they are visible at the
+	// GET /debug/vars endpoint.
+	expvar.Publish("permissions_cache", expvar.Func(func() interface{} {
+		return app.Models.Permissions.Cache.Stats()
+	}))
+	// In JWT mode, load the list of revoked sessions and keep it up to date in the
+	// background, so that logouts on other instances of the application take effect.
+	if cfg.Tokens.Format == models.TokenFormatJWT {
+		err = app.Models.JWT.LoadRevoked()
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		go func() {
+			for {
+				time.Sleep(30 * time.Second)
+				err := app.Models.JWT.LoadRevoked()
+				if err != nil {
+					logger.PrintError(err, nil)
+				}
+			}
+		}()
+	}
 	err = app.serve()
 	if err != nil {
 		logger.PrintFatal(err, nil)
@@ -79,3 +143,53 @@ func openDB(cfg models.Config) (*sql.DB, error) {
 	// Return the sql.DB connection pool.
 	return db, nil
 }
+
+// The openJWTKeys() function parses the JWT signing keys from the config struct. It
+// returns nil if JWT authentication tokens aren't enabled.
+func openJWTKeys(cfg models.Config) (*jwt.KeySet, error) {
+	switch cfg.Tokens.Format {
+	case models.TokenFormatOpaque:
+		return nil, nil
+	case models.TokenFormatJWT:
+		var keys []*jwt.Key
+		for _, spec := range cfg.Tokens.Keys {
+			key, err := jwt.ParseKey(spec)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+		}
+		return jwt.NewKeySet(cfg.Tokens.SigningKey, keys...)
+	default:
+		return nil, fmt.Errorf("invalid token format %q", cfg.Tokens.Format)
+	}
+}
+
+// The openOIDCProvider() function fetches the metadata for the OpenID Connect identity
+// provider from the config struct. It returns nil if no provider is configured.
+func openOIDCProvider(cfg models.Config) (*oidc.Provider, error) {
+	if cfg.Oidc.Issuer == "" {
+		return nil, nil
+	}
+	if cfg.Oidc.ClientID == "" || cfg.Oidc.RedirectURI == "" {
+		return nil, errors.New("-oidc-client-id and -oidc-redirect-uri are required when -oidc-issuer is set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client := &http.Client{Timeout: 10 * time.Second}
+	return oidc.Discover(ctx, cfg.Oidc.Issuer, client)
+}
+
+// The openCommentFilter() function loads the word list used to flag comments for
+// moderation. It returns nil if no word list is configured.
+func openCommentFilter(cfg models.Config) (wordfilter.Filter, error) {
+	if cfg.Comments.WordList == "" {
+		return nil, nil
+	}
+	f, err := os.Open(cfg.Comments.WordList)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return wordfilter.Load(f)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"cinemaGo/internal/models"
+	"cinemaGo/pkg/totp"
+	"cinemaGo/pkg/validator"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// The issuer name shown next to the account in authenticator apps.
+const totpIssuer = "CinemaGo"
+
+// Start enrolling the current user in two-factor authentication. We generate a new
+// secret and return it, along with an otpauth:// URI that authenticator apps can
+// import. The secret isn't used for logging in until it has been confirmed.
+func (app *application) createTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	v := validator.New()
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// SetTOTP() won't replace a secret which has already been confirmed, so an
+	// ErrEditConflict here means that two-factor authentication is already enabled.
+	_, err = app.models.MFA.SetTOTP(user.ID, secret)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrEditConflict):
+			v.AddError("totp", "two-factor authentication is already enabled")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	env := envelope{"totp": map[string]string{
+		"secret": secret,
+		"uri":    totp.URI(totpIssuer, user.Email, secret),
+	}}
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Finish enrolling the current user in two-factor authentication, by checking a code
+// from their authenticator app. On success we send back a set of recovery codes, which
+// is the only time that the user will be able to see them.
+func (app *application) confirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	var input struct {
+		Code string `json:"code"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if models.ValidateTOTPCode(v, input.Code); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	record, err := app.models.MFA.GetTOTP(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			v.AddError("totp", "two-factor authentication enrollment has not been started")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if record.Enabled() {
+		v.AddError("totp", "two-factor authentication is already enabled")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	step, ok := record.Validate(input.Code, time.Now())
+	if !ok {
+		v.AddError("code", "is incorrect")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.MFA.ConfirmTOTP(user.ID, step)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	codes, err := app.models.MFA.NewRecoveryCodes(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"recovery_codes": codes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Replace the current user's recovery codes with a new set. The password must be
+// supplied again to confirm the request.
+func (app *application) createRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	if !app.confirmPassword(w, r, user) {
+		return
+	}
+	record, err := app.models.MFA.GetTOTP(user.ID)
+	if err != nil && !errors.Is(err, models.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if record == nil || !record.Enabled() {
+		v := validator.New()
+		v.AddError("totp", "two-factor authentication is not enabled")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	codes, err := app.models.MFA.NewRecoveryCodes(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"recovery_codes": codes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Turn off two-factor authentication for the current user. The password must be
+// supplied again to confirm the request.
+func (app *application) deleteTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	if !app.confirmPassword(w, r, user) {
+		return
+	}
+	err := app.models.MFA.DeleteAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "two-factor authentication has been disabled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The confirmPassword() helper reads a {"password": "..."} request body and checks it
+// against the user's current password. If the body is invalid or the password doesn't
+// match, it sends the appropriate error response and returns false.
+func (app *application) confirmPassword(w http.ResponseWriter, r *http.Request, user *models.User) bool {
+	var input struct {
+		Password string `json:"password"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return false
+	}
+	v := validator.New()
+	if v.Check(input.Password != "", "password", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return false
+	}
+	match, err := user.Password.Matches(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+	if !match {
+		v.AddError("password", "is incorrect")
+		app.failedValidationResponse(w, r, v.Errors)
+		return false
+	}
+	return true
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"cinemaGo/internal/models"
+	"cinemaGo/pkg/jwt"
 	"cinemaGo/pkg/validator"
 	"errors"
 	"fmt"
@@ -112,6 +113,18 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		}
 		// Extract the actual authentication token from the header parts.
 		token := headerParts[1]
+		// API keys are sent in the same way, but can be told apart by their prefix.
+		// They are stored separately, so hand them off to authenticateAPIKey().
+		if models.IsAPIKey(token) {
+			app.authenticateAPIKey(w, r, next, token)
+			return
+		}
+		// In JWT mode, authentication tokens are signed JWTs which can be checked
+		// without looking them up in the tokens table.
+		if app.config.tokens.format == models.TokenFormatJWT && jwt.IsJWT(token) {
+			app.authenticateJWT(w, r, next, token)
+			return
+		}
 		// Validate the token to make sure it is in a sensible format.
 		v := validator.New()
 		// If the token isn't valid, use the invalidAuthenticationTokenResponse()
@@ -123,9 +136,9 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		}
 		// Retrieve the details of the user associated with the authentication token,
 		// again calling the invalidAuthenticationTokenResponse() helper if no
-		// matching record was found. IMPORTANT: Notice that we are using
-		// ScopeAuthentication as the first parameter here.
-		user, err := app.models.Users.GetForToken(models.ScopeAuthentication, token)
+		// matching record was found. If the token was issued to a third-party OAuth
+		// client, this also returns the permission codes that the client was granted.
+		user, scopes, err := app.models.Users.GetForAuthenticationToken(token)
 		if err != nil {
 			switch {
 			case errors.Is(err, models.ErrRecordNotFound):
@@ -135,14 +148,104 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			}
 			return
 		}
+		// Reject requests from suspended users outright. Their tokens are revoked at
+		// the time of suspension, but this also covers tokens issued by any other
+		// route and suspensions that were set directly in the database.
+		if user.IsSuspended() {
+			app.accountSuspendedResponse(w, r, user)
+			return
+		}
+		// Record that the session has been used. A failure here shouldn't stop the
+		// request from being served, so we just log it.
+		err = app.models.Tokens.UpdateLastUsed(token)
+		if err != nil {
+			app.logError(r, err)
+		}
 		// Call the contextSetUser() helper to add the user information to the request
 		// context.
 		r = app.contextSetUser(r, user)
+		// Also store the plaintext token, so that handlers can act on the current
+		// session (for example, to revoke it on logout).
+		r = app.contextSetToken(r, token)
+		if scopes != nil {
+			r = app.contextSetScopes(r, scopes)
+		}
 		// Call the next handler in the chain.
 		next.ServeHTTP(w, r)
 	})
 }
 
+// The authenticateAPIKey() helper does the work of authenticate() for requests made
+// with an API key. The key's owner becomes the request user, and the key's permissions
+// are stored in the context so that requirePermission() can apply them too.
+func (app *application) authenticateAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, plaintext string) {
+	v := validator.New()
+	if models.ValidateAPIKeyPlaintext(v, plaintext); !v.Valid() {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+	key, err := app.models.APIKeys.GetForKey(plaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	user, err := app.models.Users.Get(key.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if user.IsSuspended() {
+		app.accountSuspendedResponse(w, r, user)
+		return
+	}
+	err = app.models.APIKeys.UpdateLastUsed(key.ID)
+	if err != nil {
+		app.logError(r, err)
+	}
+	r = app.contextSetUser(r, user)
+	r = app.contextSetScopes(r, key.Permissions)
+	next.ServeHTTP(w, r)
+}
+
+// The authenticateJWT() helper does the work of authenticate() for JWT authentication
+// tokens. The signature, expiry and revocation list are all checked in memory. We still
+// load the user by their primary key, so that suspensions and other changes to the
+// account take effect straight away rather than when the token expires.
+func (app *application) authenticateJWT(w http.ResponseWriter, r *http.Request, next http.Handler, token string) {
+	userID, _, err := app.models.JWT.Verify(token)
+	if err != nil {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+	user, err := app.models.Users.Get(userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if user.IsSuspended() {
+		app.accountSuspendedResponse(w, r, user)
+		return
+	}
+	r = app.contextSetUser(r, user)
+	r = app.contextSetToken(r, token)
+	next.ServeHTTP(w, r)
+}
+
 // Create a new requireAuthenticatedUser() middleware to check that a user is not
 // anonymous.
 func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
@@ -172,14 +275,30 @@ func (app *application) requireActivatedUser(next http.HandlerFunc) http.Handler
 	return app.requireAuthenticatedUser(fn)
 }
 
+// The requireSession() middleware checks that a user is authenticated with their own
+// login session, rather than an API key or a token issued to an OAuth client. We use it
+// for the endpoints which manage the account itself, such as changing the password or
+// creating API keys, so that a leaked credential can't be used to take over the account.
+func (app *application) requireSession(next http.HandlerFunc) http.HandlerFunc {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, limited := app.contextGetScopes(r); limited {
+			app.sessionRequiredResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+	return app.requireAuthenticatedUser(fn)
+}
+
 // Note that the first parameter for the middleware function is the permission code that
 // we require the user to have.
 func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		// Retrieve the user from the request context.
 		user := app.contextGetUser(r)
-		// Get the slice of permissions for the user.
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		// Get the slice of permissions for the user, including those granted through
+		// any roles that they hold.
+		permissions, err := app.models.Permissions.GetEffectiveForUser(user.ID)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
@@ -190,6 +309,12 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 			app.notPermittedResponse(w, r)
 			return
 		}
+		// Requests made with an API key or on behalf of an OAuth client are also
+		// limited to the permissions that the credential was granted.
+		if scopes, limited := app.contextGetScopes(r); limited && !scopes.Include(code) {
+			app.notPermittedResponse(w, r)
+			return
+		}
 		// Otherwise they have the required permission so we call the next handler in
 		// the chain.
 		next.ServeHTTP(w, r)
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"cinemaGo/internal/models"
+	"cinemaGo/pkg/validator"
+	"errors"
+	"net/http"
+)
+
+// The current user's watchlist and watched log work in exactly the same way, so the
+// handlers for both are built from these helpers, given the list to work on.
+
+func (app *application) listMovieListHandler(list models.MovieListModel) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input struct {
+			models.Filters
+		}
+		v := validator.New()
+		qs := r.URL.Query()
+		input.Filters.Page = app.readInt(qs, "page", 1, v)
+		input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+		input.Filters.Sort = app.readString(qs, "sort", "-added_at")
+		input.Filters.SortSafelist = []string{"added_at", "title", "year", "-added_at", "-title", "-year"}
+		if models.ValidateFilters(v, input.Filters); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+		movies, metadata, err := list.GetAll(app.contextGetUser(r).ID, input.Filters)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	}
+}
+
+func (app *application) addToMovieListHandler(list models.MovieListModel) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input struct {
+			MovieID int64 `json:"movie_id"`
+		}
+		err := app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		v := validator.New()
+		if v.Check(input.MovieID != 0, "movie_id", "must be provided"); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+		movie, err := app.models.Movies.Get(input.MovieID)
+		if err != nil {
+			switch {
+			case errors.Is(err, models.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		addedAt, err := list.Add(app.contextGetUser(r).ID, movie.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		env := envelope{"movie": models.ListedMovie{Movie: movie, AddedAt: addedAt}}
+		err = app.writeJSON(w, http.StatusCreated, env, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	}
+}
+
+func (app *application) removeFromMovieListHandler(list models.MovieListModel) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := app.readIDParam(r)
+		if err != nil {
+			app.notFoundResponse(w, r)
+			return
+		}
+		err = list.Remove(app.contextGetUser(r).ID, id)
+		if err != nil {
+			switch {
+			case errors.Is(err, models.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully removed"}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	}
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"cinemaGo/internal/models"
+	"cinemaGo/pkg/validator"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// List the OAuth clients registered by the current user.
+func (app *application) listOAuthClientsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	clients, err := app.models.OAuth.GetAllClientsForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"clients": clients}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Register a new OAuth client owned by the current user. The client secret (for
+// confidential clients) is included in the response, and this is the only time that it
+// is available.
+func (app *application) createOAuthClientHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Confidential bool     `json:"confidential"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	client := &models.OAuthClient{
+		UserID:       app.contextGetUser(r).ID,
+		Name:         input.Name,
+		RedirectURIs: input.RedirectURIs,
+		Confidential: input.Confidential,
+	}
+	v := validator.New()
+	if models.ValidateOAuthClient(v, client); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.OAuth.InsertClient(client)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/oauth/clients/%s", client.ID))
+	err = app.writeJSON(w, http.StatusCreated, envelope{"client": client}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Delete one of the current user's OAuth clients. Every token issued to the client is
+// revoked along with it.
+func (app *application) deleteOAuthClientHandler(w http.ResponseWriter, r *http.Request) {
+	id := httprouter.ParamsFromContext(r.Context()).ByName("id")
+	user := app.contextGetUser(r)
+	err := app.models.OAuth.DeleteClient(user.ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "client successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The authorizationRequest type holds the parameters of an OAuth authorization request
+// (RFC 6749 section 4.1.1, with the PKCE parameters from RFC 7636). The Scope is a
+// space-separated list of permission codes.
+type authorizationRequest struct {
+	ResponseType        string `json:"response_type"`
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	Scope               string `json:"scope"`
+	State               string `json:"state"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+}
+
+// The checkAuthorizationRequest() helper validates an authorization request for the
+// current user, and returns the client that it is for along with the requested
+// permission codes. If the request is invalid it sends an error response and returns
+// false.
+//
+// The RFC says that most errors should be reported by redirecting back to the client,
+// but our consent screen is driven by this API, so we return them to the caller
+// instead and let it decide what to show the user.
+func (app *application) checkAuthorizationRequest(w http.ResponseWriter, r *http.Request, req authorizationRequest) (*models.OAuthClient, models.Permissions, bool) {
+	v := validator.New()
+	v.Check(req.ResponseType == "code", "response_type", "must be code")
+	v.Check(req.ClientID != "", "client_id", "must be provided")
+	v.Check(req.RedirectURI != "", "redirect_uri", "must be provided")
+	v.Check(req.CodeChallengeMethod == "S256", "code_challenge_method", "must be S256")
+	v.Check(validator.Matches(req.CodeChallenge, models.CodeChallengeRX), "code_challenge", "must be a base64url encoded SHA-256 hash")
+	scopes := models.Permissions(strings.Fields(req.Scope))
+	v.Check(len(scopes) >= 1, "scope", "must contain at least 1 permission code")
+	v.Check(validator.Unique(scopes), "scope", "must not contain duplicate values")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil, nil, false
+	}
+	client, err := app.models.OAuth.GetClient(req.ClientID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			v.AddError("client_id", "unknown client")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, nil, false
+	}
+	if !client.AllowsRedirectURI(req.RedirectURI) {
+		v.AddError("redirect_uri", "is not registered for this client")
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil, nil, false
+	}
+	// The user can only grant permissions that they hold themselves.
+	permissions, err := app.models.Permissions.GetEffectiveForUser(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, nil, false
+	}
+	for _, code := range scopes {
+		if !permissions.Include(code) {
+			v.AddError("scope", fmt.Sprintf("you don't have the %q permission", code))
+			app.failedValidationResponse(w, r, v.Errors)
+			return nil, nil, false
+		}
+	}
+	return client, scopes, true
+}
+
+// Check an authorization request and return the details needed to show the user a
+// consent screen. The request parameters are passed in the query string, just as the
+// client sent them.
+func (app *application) showAuthorizationHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	req := authorizationRequest{
+		ResponseType:        qs.Get("response_type"),
+		ClientID:            qs.Get("client_id"),
+		RedirectURI:         qs.Get("redirect_uri"),
+		Scope:               qs.Get("scope"),
+		State:               qs.Get("state"),
+		CodeChallenge:       qs.Get("code_challenge"),
+		CodeChallengeMethod: qs.Get("code_challenge_method"),
+	}
+	client, scopes, ok := app.checkAuthorizationRequest(w, r, req)
+	if !ok {
+		return
+	}
+	env := envelope{"authorization": map[string]interface{}{
+		"client":       map[string]string{"id": client.ID, "name": client.Name},
+		"redirect_uri": req.RedirectURI,
+		"scopes":       scopes,
+		"state":        req.State,
+	}}
+	err := app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Record the user's answer to an authorization request. The request parameters are
+// sent again in the body, with the scope narrowed to the permission codes that the user
+// chose to grant. We respond with the URI that the user should be redirected to, which
+// carries either an authorization code or an access_denied error.
+func (app *application) createAuthorizationHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		authorizationRequest
+		Approve bool `json:"approve"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	client, scopes, ok := app.checkAuthorizationRequest(w, r, input.authorizationRequest)
+	if !ok {
+		return
+	}
+	redirect, err := url.Parse(input.RedirectURI)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	params := redirect.Query()
+	if input.Approve {
+		code := &models.OAuthCode{
+			ClientID:      client.ID,
+			UserID:        app.contextGetUser(r).ID,
+			RedirectURI:   input.RedirectURI,
+			Permissions:   scopes,
+			CodeChallenge: input.CodeChallenge,
+		}
+		err = app.models.OAuth.NewCode(code)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		params.Set("code", code.Plaintext)
+	} else {
+		params.Set("error", "access_denied")
+	}
+	if input.State != "" {
+		params.Set("state", input.State)
+	}
+	redirect.RawQuery = params.Encode()
+	err = app.writeJSON(w, http.StatusOK, envelope{"redirect_uri": redirect.String()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The OAuth token endpoint. Following RFC 6749, the request body is form-encoded and
+// the response isn't wrapped in an envelope. Clients can exchange an authorization code
+// for tokens, or rotate a refresh token that they were issued earlier.
+func (app *application) createOAuthTokenHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
+	err := r.ParseForm()
+	if err != nil {
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_request", "the request body could not be parsed")
+		return
+	}
+	client, ok := app.authenticateOAuthClient(w, r)
+	if !ok {
+		return
+	}
+	switch grantType := r.PostForm.Get("grant_type"); grantType {
+	case "authorization_code":
+		app.exchangeAuthorizationCode(w, r, client)
+	case "refresh_token":
+		app.exchangeOAuthRefreshToken(w, r, client)
+	case "":
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_request", "grant_type must be provided")
+	default:
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "unsupported_grant_type", fmt.Sprintf("grant type %q is not supported", grantType))
+	}
+}
+
+// The authenticateOAuthClient() helper identifies the client making a token request.
+// The credentials can be sent with HTTP Basic authentication or in the request body.
+// Confidential clients must send their secret; public clients have none to send.
+func (app *application) authenticateOAuthClient(w http.ResponseWriter, r *http.Request) (*models.OAuthClient, bool) {
+	clientID, secret, basic := r.BasicAuth()
+	if !basic {
+		clientID = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+	if clientID == "" {
+		app.oauthErrorResponse(w, r, http.StatusUnauthorized, "invalid_client", "client_id must be provided")
+		return nil, false
+	}
+	client, err := app.models.OAuth.GetClient(clientID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.oauthErrorResponse(w, r, http.StatusUnauthorized, "invalid_client", "unknown client or incorrect secret")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	if client.Confidential && !client.MatchesSecret(secret) || !client.Confidential && secret != "" {
+		app.oauthErrorResponse(w, r, http.StatusUnauthorized, "invalid_client", "unknown client or incorrect secret")
+		return nil, false
+	}
+	return client, true
+}
+
+// The exchangeAuthorizationCode() helper handles the authorization_code grant. The
+// code must have been issued to this client for the same redirect URI, and the client
+// must prove that it made the original request by sending the PKCE code verifier.
+func (app *application) exchangeAuthorizationCode(w http.ResponseWriter, r *http.Request, client *models.OAuthClient) {
+	plaintext := r.PostForm.Get("code")
+	verifier := r.PostForm.Get("code_verifier")
+	if plaintext == "" || !validator.Matches(verifier, models.CodeVerifierRX) {
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_request", "code and a valid code_verifier must be provided")
+		return
+	}
+	// The code is deleted as it is read, so even a failed attempt uses it up. This stops
+	// anyone from trying verifiers against a stolen code.
+	code, err := app.models.OAuth.ConsumeCode(plaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_grant", "invalid or expired authorization code")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if code.ClientID != client.ID || code.RedirectURI != r.PostForm.Get("redirect_uri") || !code.VerifyCodeVerifier(verifier) {
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_grant", "invalid or expired authorization code")
+		return
+	}
+	user, err := app.models.Users.Get(code.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_grant", "invalid or expired authorization code")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if user.IsSuspended() {
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_grant", "the user's account has been suspended")
+		return
+	}
+	token, refreshToken, err := app.models.Tokens.NewOAuthSession(user.ID, client.ID, code.Permissions, app.config.tokens.accessTTL, app.config.tokens.refreshTTL, r.UserAgent(), app.readClientIP(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.writeOAuthTokens(w, r, token, refreshToken)
+}
+
+// The exchangeOAuthRefreshToken() helper handles the refresh_token grant. It works the
+// same way as POST /v1/tokens/refresh, except that the refresh token must have been
+// issued to this client.
+func (app *application) exchangeOAuthRefreshToken(w http.ResponseWriter, r *http.Request, client *models.OAuthClient) {
+	plaintext := r.PostForm.Get("refresh_token")
+	v := validator.New()
+	if models.ValidateTokenPlaintext(v, plaintext); !v.Valid() {
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_request", "a valid refresh_token must be provided")
+		return
+	}
+	user, err := app.models.Users.GetForToken(models.ScopeRefresh, plaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_grant", "invalid or expired refresh token")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if user.IsSuspended() {
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_grant", "the user's account has been suspended")
+		return
+	}
+	token, refreshToken, err := app.models.Tokens.Rotate(plaintext, client.ID, app.config.tokens.accessTTL, app.config.tokens.refreshTTL, r.UserAgent(), app.readClientIP(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound), errors.Is(err, models.ErrRefreshTokenReused):
+			app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_grant", "invalid or expired refresh token")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.writeOAuthTokens(w, r, token, refreshToken)
+}
+
+// The writeOAuthTokens() helper sends a successful token response in the format from
+// RFC 6749 section 5.1.
+func (app *application) writeOAuthTokens(w http.ResponseWriter, r *http.Request, token, refreshToken *models.Token) {
+	env := envelope{
+		"access_token":  token.Plaintext,
+		"token_type":    "Bearer",
+		"expires_in":    int(time.Until(token.Expiry).Seconds()),
+		"refresh_token": refreshToken.Plaintext,
+		"scope":         strings.Join(token.Permissions, " "),
+	}
+	headers := make(http.Header)
+	headers.Set("Cache-Control", "no-store")
+	err := app.writeJSON(w, http.StatusOK, env, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
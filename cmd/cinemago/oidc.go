@@ -0,0 +1,254 @@
+package main
+
+import (
+	"cinemaGo/internal/models"
+	"cinemaGo/pkg/oidc"
+	"cinemaGo/pkg/validator"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Start signing in with the external identity provider. We respond with the URL that
+// the client should send the user to. Once they have signed in, the provider redirects
+// them back to our web client with a code and state, which are exchanged for tokens at
+// POST /v1/tokens/oidc.
+func (app *application) createOIDCLoginHandler(w http.ResponseWriter, r *http.Request) {
+	provider := app.models.OIDC.Provider
+	if provider == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	login, err := app.models.OIDC.NewLogin()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	authURL, err := provider.AuthCodeURL(app.config.oidc.clientID, app.config.oidc.redirectURI, login.State, login.Nonce, login.CodeChallenge())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authorization_url": authURL}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Finish signing in with the external identity provider. The authorization code is
+// redeemed at the provider for an ID token, which tells us who the user is, and we
+// start a normal session for them just as if they had logged in with a password.
+//
+// Signing in with the provider takes the place of the password only. Users with
+// two-factor authentication enabled get an 'mfa' token, which must be exchanged along
+// with a TOTP or recovery code at POST /v1/tokens/mfa, as they would after a password.
+func (app *application) createOIDCAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	provider := app.models.OIDC.Provider
+	if provider == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	var input struct {
+		Code  string `json:"code"`
+		State string `json:"state"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	v.Check(input.Code != "", "code", "must be provided")
+	v.Check(input.State != "", "state", "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	login, err := app.models.OIDC.ConsumeLogin(input.State)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			v.AddError("state", "invalid or expired state")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	rawIDToken, err := provider.Exchange(r.Context(), app.config.oidc.clientID, app.config.oidc.clientSecret, app.config.oidc.redirectURI, input.Code, login.CodeVerifier)
+	if err != nil {
+		switch {
+		case errors.Is(err, oidc.ErrExchangeFailed):
+			v.AddError("code", "invalid or expired authorization code")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	idToken, err := provider.VerifyIDToken(r.Context(), rawIDToken, app.config.oidc.clientID, time.Now())
+	if err != nil {
+		switch {
+		case errors.Is(err, oidc.ErrInvalidToken), errors.Is(err, oidc.ErrExpiredToken), errors.Is(err, oidc.ErrUnknownKey):
+			v.AddError("code", "the identity provider returned an invalid ID token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// The nonce ties the ID token to this login, so that a token issued for some other
+	// login can't be replayed here.
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(login.Nonce)) != 1 {
+		v.AddError("code", "the identity provider returned an invalid ID token")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	user, ok := app.userForIDToken(w, r, idToken)
+	if !ok {
+		return
+	}
+	if user.IsSuspended() {
+		app.accountSuspendedResponse(w, r, user)
+		return
+	}
+	if app.sendMFAChallenge(w, r, user) {
+		return
+	}
+	token, refreshToken, err := app.models.Tokens.NewSession(user.ID, app.config.tokens.accessTTL, app.config.tokens.refreshTTL, r.UserAgent(), app.readClientIP(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	token, err = app.signSessionToken(token)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	env := envelope{"authentication_token": token, "refresh_token": refreshToken}
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The userForIDToken() helper finds the user that an ID token identifies. Users who
+// have signed in with the provider before are found by their identity there. Otherwise
+// we look for a user with the same email address and link them, or create a new user
+// if there isn't one. Both of these rely on the email address, so the provider must
+// have verified it. If anything goes wrong it sends an error response and returns
+// false.
+func (app *application) userForIDToken(w http.ResponseWriter, r *http.Request, idToken *oidc.IDToken) (*models.User, bool) {
+	userID, err := app.models.OIDC.GetUserIDForIdentity(idToken.Issuer, idToken.Subject)
+	switch {
+	case err == nil:
+		user, err := app.models.Users.Get(userID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return nil, false
+		}
+		return user, true
+	case !errors.Is(err, models.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+	v := validator.New()
+	models.ValidateEmail(v, idToken.Email)
+	v.Check(idToken.EmailVerified, "email", "must be verified by the identity provider")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil, false
+	}
+	user, err := app.models.Users.GetByEmail(idToken.Email)
+	switch {
+	case err == nil:
+		// The provider has confirmed that the user owns the email address, which is
+		// all that activation does.
+		if !user.Activated {
+			err = app.claimUnactivatedUser(user)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return nil, false
+			}
+		}
+	case errors.Is(err, models.ErrRecordNotFound):
+		user, err = app.createOIDCUser(idToken)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return nil, false
+		}
+	default:
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+	err = app.models.OIDC.LinkIdentity(user.ID, idToken.Issuer, idToken.Subject)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+	return user, true
+}
+
+// The claimUnactivatedUser() helper activates an existing user on behalf of someone
+// who has proved to the identity provider that they own its email address. Anybody can
+// register with any email address, and can sign in before activating, so the account
+// may have been set up in advance by an attacker waiting for the real owner to activate
+// it. We therefore throw away everything that whoever registered it could have used to
+// keep access: the password is replaced with a random one, and their sessions, API keys
+// and outstanding tokens are revoked, along with any pending email change.
+func (app *application) claimUnactivatedUser(user *models.User) error {
+	err := app.revokeAllSessions(user.ID)
+	if err != nil {
+		return err
+	}
+	err = app.models.APIKeys.DeleteAllForUser(user.ID)
+	if err != nil {
+		return err
+	}
+	for _, scope := range []string{models.ScopeActivation, models.ScopePasswordReset, models.ScopeEmailChange, models.ScopeMFA} {
+		err = app.models.Tokens.DeleteAllForUser(scope, user.ID)
+		if err != nil {
+			return err
+		}
+	}
+	err = user.Password.SetRandom()
+	if err != nil {
+		return err
+	}
+	user.PendingEmail = ""
+	user.Activated = true
+	return app.models.Users.Update(user)
+}
+
+// The createOIDCUser() helper creates an activated user for someone signing in with the
+// identity provider for the first time. They are given a random password, and the same
+// permissions as a user who registers themselves.
+func (app *application) createOIDCUser(idToken *oidc.IDToken) (*models.User, error) {
+	name := strings.TrimSpace(idToken.Name)
+	if name == "" {
+		name, _, _ = strings.Cut(idToken.Email, "@")
+	}
+	if len(name) > 500 {
+		name = name[:500]
+	}
+	user := &models.User{
+		Name:      name,
+		Email:     idToken.Email,
+		Activated: true,
+	}
+	err := user.Password.SetRandom()
+	if err != nil {
+		return nil, err
+	}
+	err = app.models.Users.Insert(user)
+	if err != nil {
+		return nil, err
+	}
+	err = app.models.Permissions.AddForUser(user.ID, "movies:read")
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"expvar"
 	"net/http"
 
 	"github.com/julienschmidt/httprouter"
@@ -18,6 +19,10 @@ func (app *application) routes() http.Handler {
 	// Use the requirePermission() middleware on each of the /v1/movies** endpoints,
 	// passing in the required permission code as the first parameter.
 	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
+	// Register a new GET /debug/vars endpoint pointing to the expvar handler. The
+	// variables include the command-line flags, and so the database DSN and SMTP
+	// password, so the endpoint is restricted to user administrators.
+	router.HandlerFunc(http.MethodGet, "/debug/vars", app.requirePermission("users:admin", expvar.Handler().ServeHTTP))
 	router.HandlerFunc(http.MethodGet, "/v1/movies", app.requirePermission("movies:read", app.listMoviesHandler))
 	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission("movies:write", app.createMovieHandler))
 	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermission("movies:read", app.showMovieHandler))
@@ -29,6 +34,131 @@ func (app *application) routes() http.Handler {
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
 	// Add the route for the POST /v1/tokens/authentication endpoint.
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	// Add the routes for the password reset flow.
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateUserPasswordHandler)
+	// Add the route for the POST /v1/tokens/activation endpoint.
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.createActivationTokenHandler)
+	// Add the routes for revoking authentication tokens. Both require the request to
+	// have been authenticated by the authenticate() middleware.
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication", app.requireSession(app.deleteAuthenticationTokenHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication/all", app.requireSession(app.deleteAllAuthenticationTokensHandler))
+	// Add the routes for listing and revoking the current user's sessions.
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/sessions", app.requireSession(app.listSessionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/sessions/:id", app.requireSession(app.deleteSessionHandler))
+	// Add the routes for viewing and updating the current user's profile. These need the
+	// user's own session, as API keys and OAuth clients are limited to the permission
+	// codes they were granted, which don't cover the account itself.
+	router.HandlerFunc(http.MethodGet, "/v1/users/me", app.requireSession(app.showCurrentUserHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/me", app.requireSession(app.updateCurrentUserHandler))
+	// Add the routes for changing the current user's email address.
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/email", app.requireSession(app.requireActivatedUser(app.requestEmailChangeHandler)))
+	router.HandlerFunc(http.MethodPut, "/v1/users/email", app.confirmEmailChangeHandler)
+	// Add the route for changing the current user's password.
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/password", app.requireSession(app.updateCurrentUserPasswordHandler))
+	// Add the routes for deleting the current user's account and exporting their data.
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me", app.requireSession(app.deleteCurrentUserHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/export", app.requireSession(app.exportCurrentUserHandler))
+	// Add the routes for managing permissions. These all live under /v1/admin, so that
+	// the :id wildcard doesn't clash with the fixed /v1/users/* paths above.
+	router.HandlerFunc(http.MethodGet, "/v1/admin/permissions", app.requirePermission("permissions:admin", app.listPermissionsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/permissions", app.requirePermission("permissions:admin", app.createPermissionHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id/permissions", app.requirePermission("permissions:admin", app.listUserPermissionsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/permissions", app.requirePermission("permissions:admin", app.grantUserPermissionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/permissions", app.requirePermission("permissions:admin", app.revokeUserPermissionsHandler))
+	// Add the routes for managing roles and role assignments.
+	router.HandlerFunc(http.MethodGet, "/v1/admin/roles", app.requirePermission("permissions:admin", app.listRolesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/roles", app.requirePermission("permissions:admin", app.createRoleHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/admin/roles/:id", app.requirePermission("permissions:admin", app.updateRoleHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/roles/:id", app.requirePermission("permissions:admin", app.deleteRoleHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id/roles", app.requirePermission("permissions:admin", app.listUserRolesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/roles", app.requirePermission("permissions:admin", app.assignUserRolesHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/roles", app.requirePermission("permissions:admin", app.removeUserRolesHandler))
+	// Add the routes for managing user accounts.
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users", app.requirePermission("users:admin", app.listUsersHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id", app.requirePermission("users:admin", app.showUserHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/admin/users/:id/activated", app.requirePermission("users:admin", app.updateUserActivatedHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/tokens", app.requirePermission("users:admin", app.logoutUserHandler))
+	// Add the routes for suspending users and lifting suspensions.
+	router.HandlerFunc(http.MethodPut, "/v1/admin/users/:id/suspension", app.requirePermission("users:admin", app.suspendUserHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/suspension", app.requirePermission("users:admin", app.unsuspendUserHandler))
+	// Add the routes for two-factor authentication.
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/mfa", app.createMFAAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/mfa/totp", app.requireSession(app.requireActivatedUser(app.createTOTPHandler)))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/mfa/totp", app.requireSession(app.requireActivatedUser(app.confirmTOTPHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/mfa/totp", app.requireSession(app.requireActivatedUser(app.deleteTOTPHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/mfa/recovery-codes", app.requireSession(app.requireActivatedUser(app.createRecoveryCodesHandler)))
+	// Add the routes for managing the current user's API keys.
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/api-keys", app.requireSession(app.listAPIKeysHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/api-keys", app.requireSession(app.createAPIKeyHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/api-keys/:id", app.requireSession(app.deleteAPIKeyHandler))
+	// Add the route for renewing an authentication token with a refresh token.
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/refresh", app.refreshAuthenticationTokenHandler)
+	// Add the routes for the OAuth authorization server. Clients are managed by the
+	// users who register them, and the authorization endpoints are called by our own
+	// consent screen on the user's behalf. The token endpoint authenticates clients
+	// rather than users.
+	router.HandlerFunc(http.MethodGet, "/v1/oauth/clients", app.requireSession(app.requireActivatedUser(app.listOAuthClientsHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/oauth/clients", app.requireSession(app.requireActivatedUser(app.createOAuthClientHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/oauth/clients/:id", app.requireSession(app.requireActivatedUser(app.deleteOAuthClientHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/oauth/authorize", app.requireSession(app.requireActivatedUser(app.showAuthorizationHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/oauth/authorize", app.requireSession(app.requireActivatedUser(app.createAuthorizationHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/oauth/token", app.createOAuthTokenHandler)
+	// Add the routes for signing in with an external OpenID Connect identity provider.
+	router.HandlerFunc(http.MethodPost, "/v1/oidc/logins", app.createOIDCLoginHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/oidc", app.createOIDCAuthenticationTokenHandler)
+	// Add the routes for people and their movie credits. These use the same
+	// permissions as the movies themselves.
+	router.HandlerFunc(http.MethodGet, "/v1/people", app.requirePermission("movies:read", app.listPeopleHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/people", app.requirePermission("movies:write", app.createPersonHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/people/:id", app.requirePermission("movies:read", app.showPersonHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/people/:id", app.requirePermission("movies:write", app.updatePersonHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/people/:id", app.requirePermission("movies:write", app.deletePersonHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/credits", app.requirePermission("movies:read", app.listMovieCreditsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/credits", app.requirePermission("movies:write", app.createMovieCreditHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/credits/:id", app.requirePermission("movies:read", app.showCreditHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/credits/:id", app.requirePermission("movies:write", app.updateCreditHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/credits/:id", app.requirePermission("movies:write", app.deleteCreditHandler))
+	// Add the routes for movie reviews. Any activated user who can read movies can
+	// review them, but they can only edit and delete their own reviews.
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/reviews", app.requirePermission("movies:read", app.listMovieReviewsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/reviews", app.requirePermission("movies:read", app.createMovieReviewHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/reviews/:id", app.requirePermission("movies:read", app.showReviewHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/reviews/:id", app.requirePermission("movies:read", app.updateReviewHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/reviews/:id", app.requirePermission("movies:read", app.deleteReviewHandler))
+	// Add the routes for comments on movies, and for moderating them. Any activated
+	// user who can read movies can comment, but they can only edit and delete their own
+	// comments.
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/comments", app.requirePermission("movies:read", app.listMovieCommentsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/comments", app.requirePermission("movies:read", app.createMovieCommentHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/comments/:id", app.requirePermission("movies:read", app.showCommentHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/comments/:id", app.requirePermission("movies:read", app.updateCommentHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/comments/:id", app.requirePermission("movies:read", app.deleteCommentHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/comments/:id/reports", app.requirePermission("movies:read", app.createCommentReportHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/moderation/comments", app.requirePermission("comments:moderate", app.listModerationQueueHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/moderation/comments/:id", app.requirePermission("comments:moderate", app.showCommentModerationHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/moderation/comments/:id/hide", app.requirePermission("comments:moderate", app.hideCommentHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/moderation/comments/:id/restore", app.requirePermission("comments:moderate", app.restoreCommentHandler))
+	// Add the routes for the current user's watchlist and log of watched movies. The
+	// :id parameter is the ID of the movie to remove.
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/watchlist", app.requirePermission("movies:read", app.listMovieListHandler(app.models.Watchlist)))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/watchlist", app.requirePermission("movies:read", app.addToMovieListHandler(app.models.Watchlist)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/watchlist/:id", app.requirePermission("movies:read", app.removeFromMovieListHandler(app.models.Watchlist)))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/watched", app.requirePermission("movies:read", app.listMovieListHandler(app.models.Watched)))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/watched", app.requirePermission("movies:read", app.addToMovieListHandler(app.models.Watched)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/watched/:id", app.requirePermission("movies:read", app.removeFromMovieListHandler(app.models.Watched)))
+	// Add the routes for cinemas and their halls. Anyone can read them, but changing
+	// them requires the cinemas:write permission.
+	router.HandlerFunc(http.MethodGet, "/v1/cinemas", app.listCinemasHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/cinemas", app.requirePermission("cinemas:write", app.createCinemaHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/cinemas/:id", app.showCinemaHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/cinemas/:id", app.requirePermission("cinemas:write", app.updateCinemaHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/cinemas/:id", app.requirePermission("cinemas:write", app.deleteCinemaHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/cinemas/:id/halls", app.listCinemaHallsHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/cinemas/:id/halls", app.requirePermission("cinemas:write", app.createCinemaHallHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/halls/:id", app.showHallHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/halls/:id", app.requirePermission("cinemas:write", app.updateHallHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/halls/:id", app.requirePermission("cinemas:write", app.deleteHallHandler))
 	// Add the enableCORS() middleware.
 	return app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router))))
 }
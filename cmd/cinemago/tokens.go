@@ -2,9 +2,11 @@ package main
 
 import (
 	"cinemaGo/internal/models"
+	"cinemaGo/pkg/jwt"
 	"cinemaGo/pkg/validator"
 	"errors"
 	"net/http"
+	"strconv"
 	"time"
 )
 
@@ -27,6 +29,20 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
+	// Check whether there have been too many recent failed attempts for this email
+	// address or from this IP address. If so, tell the client how long to wait before
+	// trying again, without even looking at the password. Otherwise the attempt is
+	// recorded as a failure until the password turns out to be correct.
+	ip := app.readClientIP(r)
+	retryAfter, err := app.models.LoginAttempts.Begin(input.Email, ip)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if retryAfter > 0 {
+		app.tooManyLoginAttemptsResponse(w, r, retryAfter)
+		return
+	}
 	// Lookup the user record based on the email address. If no matching user was
 	// found, then we call the app.invalidCredentialsResponse() helper to send a 401
 	// Unauthorized response to the client (we will create this helper in a moment).
@@ -34,7 +50,7 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	if err != nil {
 		switch {
 		case errors.Is(err, models.ErrRecordNotFound):
-			app.invalidCredentialsResponse(w, r)
+			app.recordFailedLogin(w, r, input.Email, ip, nil)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
@@ -46,23 +62,557 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	// If the passwords don't match, then we call the app.invalidCredentialsResponse()
-	// helper again and return.
+	// If the passwords don't match, then we record the failure and send the
+	// invalidCredentialsResponse() again.
 	if !match {
-		app.invalidCredentialsResponse(w, r)
+		app.recordFailedLogin(w, r, input.Email, ip, user)
+		return
+	}
+	// Don't issue tokens to suspended users.
+	if user.IsSuspended() {
+		app.accountSuspendedResponse(w, r, user)
+		return
+	}
+	// If the user has two-factor authentication enabled, the password alone isn't
+	// enough.
+	if app.sendMFAChallenge(w, r, user) {
+		return
+	}
+	// The user has signed in, so forget about any earlier failures for this email
+	// address. We don't do this until now, as failed codes at POST /v1/tokens/mfa are
+	// counted against the same address, and the password alone mustn't reset them.
+	err = app.models.LoginAttempts.DeleteAllForEmail(input.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// Otherwise, if the password is correct, we start a new session: a short-lived
+	// token with the scope 'authentication', and a refresh token which the client can
+	// use to renew it. Both record the client details so that the user can recognise
+	// the session later.
+	token, refreshToken, err := app.models.Tokens.NewSession(user.ID, app.config.tokens.accessTTL, app.config.tokens.refreshTTL, r.UserAgent(), ip)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
 		return
 	}
-	// Otherwise, if the password is correct, we generate a new token with a 24-hour
-	// expiry time and the scope 'authentication'.
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, models.ScopeAuthentication)
+	token, err = app.signSessionToken(token)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	// Encode the token to JSON and send it in the response along with a 201 Created
+	// Encode the tokens to JSON and send them in the response along with a 201 Created
 	// status code.
-	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	env := envelope{"authentication_token": token, "refresh_token": refreshToken}
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The sendMFAChallenge() helper checks whether the user has two-factor authentication
+// enabled. If so, instead of an authentication token we issue a short-lived 'mfa'
+// token, which the client must exchange along with a TOTP or recovery code at the
+// POST /v1/tokens/mfa endpoint. It returns true if it has sent a response (including an
+// error response), in which case the caller must not start a session.
+func (app *application) sendMFAChallenge(w http.ResponseWriter, r *http.Request, user *models.User) bool {
+	totp, err := app.models.MFA.GetTOTP(user.ID)
+	if err != nil && !errors.Is(err, models.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return true
+	}
+	if totp == nil || !totp.Enabled() {
+		return false
+	}
+	mfaToken, err := app.models.Tokens.New(user.ID, 5*time.Minute, models.ScopeMFA)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return true
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"mfa_token": mfaToken}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+	return true
+}
+
+// Exchange an 'mfa' token and a TOTP code (or one of the user's recovery codes) for an
+// authentication token. This is the second step of logging in for users with
+// two-factor authentication enabled.
+func (app *application) createMFAAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recovery_code"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	models.ValidateTokenPlaintext(v, input.TokenPlaintext)
+	switch {
+	case input.Code != "" && input.RecoveryCode != "":
+		v.AddError("code", "must not be provided together with recovery_code")
+	case input.RecoveryCode != "":
+		models.ValidateRecoveryCode(v, input.RecoveryCode)
+	default:
+		models.ValidateTOTPCode(v, input.Code)
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	user, err := app.models.Users.GetForToken(models.ScopeMFA, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired mfa token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if user.IsSuspended() {
+		app.accountSuspendedResponse(w, r, user)
+		return
+	}
+	totp, err := app.models.MFA.GetTOTP(user.ID)
+	if err != nil && !errors.Is(err, models.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// Two-factor authentication may have been turned off since the mfa token was
+	// issued, in which case the client needs to log in again from the start.
+	if totp == nil || !totp.Enabled() {
+		v.AddError("token", "invalid or expired mfa token")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	// Failed codes count towards the same limits as failed passwords, which stops the
+	// 6 digit codes from being guessed.
+	ip := app.readClientIP(r)
+	retryAfter, err := app.models.LoginAttempts.Begin(user.Email, ip)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if retryAfter > 0 {
+		app.tooManyLoginAttemptsResponse(w, r, retryAfter)
+		return
+	}
+	if input.RecoveryCode != "" {
+		err = app.models.MFA.UseRecoveryCode(user.ID, input.RecoveryCode)
+		if err != nil {
+			switch {
+			case errors.Is(err, models.ErrRecordNotFound):
+				app.recordFailedLogin(w, r, user.Email, ip, user)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	} else {
+		step, ok := totp.Validate(input.Code, time.Now())
+		if !ok {
+			app.recordFailedLogin(w, r, user.Email, ip, user)
+			return
+		}
+		// Each code may only be used once, so that a code seen by an attacker can't be
+		// replayed within its 30 second window.
+		err = app.models.MFA.UseTOTPStep(user.ID, step)
+		if err != nil {
+			switch {
+			case errors.Is(err, models.ErrTOTPCodeReused):
+				app.recordFailedLogin(w, r, user.Email, ip, user)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+	err = app.models.Tokens.DeleteAllForUser(models.ScopeMFA, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.models.LoginAttempts.DeleteAllForEmail(user.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	token, refreshToken, err := app.models.Tokens.NewSession(user.ID, app.config.tokens.accessTTL, app.config.tokens.refreshTTL, r.UserAgent(), ip)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	token, err = app.signSessionToken(token)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	env := envelope{"authentication_token": token, "refresh_token": refreshToken}
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Exchange a refresh token for a new authentication token and refresh token. The old
+// refresh token can't be used again; if it is, the whole session is revoked.
+func (app *application) refreshAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if models.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	// Look up the user first, so that a suspended user can't keep their session going
+	// by refreshing it.
+	user, err := app.models.Users.GetForToken(models.ScopeRefresh, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if user.IsSuspended() {
+		app.accountSuspendedResponse(w, r, user)
+		return
+	}
+	token, refreshToken, err := app.models.Tokens.Rotate(input.TokenPlaintext, "", app.config.tokens.accessTTL, app.config.tokens.refreshTTL, r.UserAgent(), app.readClientIP(r))
+	if err != nil {
+		switch {
+		// The refresh token was rotated by a concurrent request after we looked up
+		// the user.
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		// The refresh token had already been used, and Rotate() has revoked the whole
+		// session. Log this, as it suggests that the token has been stolen.
+		case errors.Is(err, models.ErrRefreshTokenReused):
+			app.logger.PrintInfo("refresh token reused, session revoked", map[string]string{
+				"user_id": strconv.FormatInt(user.ID, 10),
+				"ip":      app.readClientIP(r),
+			})
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	token, err = app.signSessionToken(token)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+		return
+	}
+	env := envelope{"authentication_token": token, "refresh_token": refreshToken}
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The recordFailedLogin() helper sends the invalidCredentialsResponse() for a failed
+// login attempt, which LoginAttempts.Begin() has already recorded. If this failure is
+// the one that locks the account, and the account exists, the owner is sent an email
+// letting them know.
+func (app *application) recordFailedLogin(w http.ResponseWriter, r *http.Request, email, ip string, user *models.User) {
+	if user != nil {
+		status, err := app.models.LoginAttempts.GetStatus(email, ip)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if status.AccountFailures == models.LoginAccountLockoutThreshold {
+			app.background(func() {
+				data := map[string]interface{}{
+					"ip":              ip,
+					"lockoutDuration": models.LoginLockoutDuration.String(),
+				}
+				err := app.mailer.Send(user.Email, "user_account_locked.tmpl", data)
+				if err != nil {
+					app.logger.PrintError(err, nil)
+				}
+			})
+		}
+	}
+	app.invalidCredentialsResponse(w, r)
+}
+
+// Generate a password reset token and send it to the user's email address.
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse and validate the user's email address.
+	var input struct {
+		Email string `json:"email"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if models.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	// Try to retrieve the corresponding user record for the email address. If it can't
+	// be found, return an error message to the client.
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			v.AddError("email", "no matching email address found")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// Return an error message if the user is not activated.
+	if !user.Activated {
+		v.AddError("email", "user account must be activated")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	// Otherwise, create a new password reset token with a 45-minute expiry time.
+	token, err := app.models.Tokens.New(user.ID, 45*time.Minute, models.ScopePasswordReset)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// Email the user with their password reset token.
+	app.background(func() {
+		data := map[string]interface{}{
+			"passwordResetToken": token.Plaintext,
+		}
+		// Since email addresses MAY be case sensitive, notice that we are sending this
+		// email using the address stored in our database for the user --- not to the
+		// input.Email address provided by the client in this request.
+		err = app.mailer.Send(user.Email, "token_password_reset.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+	// Send a 202 Accepted response and confirmation message to the client.
+	env := envelope{"message": "an email will be sent to you containing password reset instructions"}
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Generate a fresh activation token for a user whose original token was lost or has
+// expired, and send it to their email address.
+func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse and validate the user's email address.
+	var input struct {
+		Email string `json:"email"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if models.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	// Try to retrieve the corresponding user record for the email address. If it can't
+	// be found, return an error message to the client.
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			v.AddError("email", "no matching email address found")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// Return an error if the user has already been activated.
+	if user.Activated {
+		v.AddError("email", "user has already been activated")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	// Delete any activation tokens left over from earlier requests, so that only the
+	// most recently sent token can be used.
+	err = app.models.Tokens.DeleteAllForUser(models.ScopeActivation, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// Otherwise, create a new activation token.
+	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, models.ScopeActivation)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// Email the user with their additional activation token.
+	app.background(func() {
+		data := map[string]interface{}{
+			"activationToken": token.Plaintext,
+		}
+		// Since email addresses MAY be case sensitive, notice that we are sending this
+		// email using the address stored in our database for the user --- not to the
+		// input.Email address provided by the client in this request.
+		err = app.mailer.Send(user.Email, "token_activation.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+	// Send a 202 Accepted response and confirmation message to the client.
+	env := envelope{"message": "an email will be sent to you containing activation instructions"}
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Revoke the authentication token that was used to make the current request.
+func (app *application) deleteAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	id, err := app.currentSessionID(r)
+	if err == nil {
+		err = app.revokeSession(user.ID, id)
+	}
+	if err != nil {
+		switch {
+		// The token may have been revoked by a concurrent request between the
+		// authenticate() middleware running and now, in which case there is nothing
+		// left to delete.
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "you have been successfully logged out"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Revoke every authentication token for the current user, logging out all of their
+// sessions on all devices.
+func (app *application) deleteAllAuthenticationTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	err := app.revokeAllSessions(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "all sessions have been successfully logged out"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// List the current user's active sessions, including those which are only being kept
+// alive by their refresh token. The token hashes are never included in the response.
+func (app *application) listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	sessions, err := app.models.Tokens.GetAllSessionsForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"sessions": sessions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Revoke a single session belonging to the current user.
+func (app *application) deleteSessionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	user := app.contextGetUser(r)
+	// Scoping the delete to the current user means that a session belonging to someone
+	// else is reported as not found, rather than leaking its existence.
+	err = app.revokeSession(user.ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "session successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The signSessionToken() helper returns the authentication token that should be given
+// to the client for a session. In JWT mode this is a signed JWT which refers to the
+// session, rather than the opaque token stored in the tokens table.
+func (app *application) signSessionToken(token *models.Token) (*models.Token, error) {
+	if app.config.tokens.format != models.TokenFormatJWT {
+		return token, nil
+	}
+	return app.models.JWT.New(token)
+}
+
+// The currentSessionID() helper returns the ID of the session that the current request
+// was authenticated with.
+func (app *application) currentSessionID(r *http.Request) (int64, error) {
+	token := app.contextGetToken(r)
+	if jwt.IsJWT(token) {
+		_, id, err := app.models.JWT.Verify(token)
+		return id, err
+	}
+	return app.models.Tokens.GetSessionID(token)
+}
+
+// The revokeSession(), revokeOtherSessions() and revokeAllSessions() helpers delete
+// sessions from the tokens table. In JWT mode the sessions are added to the revocation
+// list first, as their JWTs would otherwise remain valid until they expire.
+func (app *application) revokeSession(userID, id int64) error {
+	if app.config.tokens.format == models.TokenFormatJWT {
+		err := app.models.JWT.RevokeSession(userID, id)
+		if err != nil {
+			return err
+		}
+	}
+	return app.models.Tokens.DeleteSession(userID, id)
+}
+
+func (app *application) revokeOtherSessions(userID, exceptID int64) error {
+	if app.config.tokens.format == models.TokenFormatJWT {
+		err := app.models.JWT.RevokeSessionsForUser(userID, exceptID)
+		if err != nil {
+			return err
+		}
+	}
+	return app.models.Tokens.DeleteOtherSessionsForUser(userID, exceptID)
+}
+
+func (app *application) revokeAllSessions(userID int64) error {
+	if app.config.tokens.format == models.TokenFormatJWT {
+		err := app.models.JWT.RevokeSessionsForUser(userID, 0)
+		if err != nil {
+			return err
+		}
 	}
+	return app.models.Tokens.DeleteAllSessionsForUser(userID)
 }
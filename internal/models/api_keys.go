@@ -0,0 +1,255 @@
+package models
+
+import (
+	"cinemaGo/pkg/validator"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// API keys all start with this prefix, which is how the authenticate() middleware tells
+// them apart from authentication tokens in the Authorization header.
+const APIKeyPrefix = "cgk_"
+
+// An APIKey is a long-lived credential owned by a user, intended for scripts and other
+// services. Each key carries its own set of permission codes, which can only ever
+// narrow what the owner is allowed to do. The Plaintext is only available at the moment
+// the key is created; afterwards the Prefix is enough for the owner to recognise it.
+type APIKey struct {
+	ID          int64       `json:"id"`
+	Plaintext   string      `json:"key,omitempty"`
+	Prefix      string      `json:"prefix"`
+	Hash        []byte      `json:"-"`
+	UserID      int64       `json:"-"`
+	Name        string      `json:"name"`
+	Permissions Permissions `json:"permissions"`
+	CreatedAt   time.Time   `json:"created_at"`
+	LastUsedAt  *time.Time  `json:"last_used_at,omitempty"`
+	Expiry      *time.Time  `json:"expiry,omitempty"`
+}
+
+func generateAPIKey(userID int64, name string, permissions Permissions, expiry *time.Time) (*APIKey, error) {
+	key := &APIKey{
+		UserID:      userID,
+		Name:        name,
+		Permissions: permissions,
+		Expiry:      expiry,
+	}
+	randomBytes := make([]byte, 20)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+	key.Plaintext = APIKeyPrefix + base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	key.Prefix = key.Plaintext[:len(APIKeyPrefix)+8]
+	hash := sha256.Sum256([]byte(key.Plaintext))
+	key.Hash = hash[:]
+	return key, nil
+}
+
+// The IsAPIKey() function reports whether a bearer credential looks like an API key
+// rather than an authentication token.
+func IsAPIKey(plaintext string) bool {
+	return strings.HasPrefix(plaintext, APIKeyPrefix)
+}
+
+// Check that the plaintext key has the prefix followed by 32 characters.
+func ValidateAPIKeyPlaintext(v *validator.Validator, plaintext string) {
+	v.Check(plaintext != "", "key", "must be provided")
+	v.Check(IsAPIKey(plaintext), "key", "must start with "+APIKeyPrefix)
+	v.Check(len(plaintext) == len(APIKeyPrefix)+32, "key", "must be 36 bytes long")
+}
+
+func ValidateAPIKey(v *validator.Validator, key *APIKey) {
+	v.Check(key.Name != "", "name", "must be provided")
+	v.Check(len(key.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(key.Permissions != nil, "permissions", "must be provided")
+	v.Check(len(key.Permissions) >= 1, "permissions", "must contain at least 1 code")
+	v.Check(validator.Unique(key.Permissions), "permissions", "must not contain duplicate values")
+	for _, code := range key.Permissions {
+		if !validator.Matches(code, PermissionCodeRX) {
+			v.AddError("permissions", "must all be in the format resource:action")
+			break
+		}
+	}
+	if key.Expiry != nil {
+		v.Check(key.Expiry.After(time.Now()), "expiry", "must be in the future")
+	}
+}
+
+// Define the APIKeyModel type.
+type APIKeyModel struct {
+	DB *sql.DB
+}
+
+// The New() method generates a new API key and stores it, along with its permission
+// codes, in a single transaction.
+func (m APIKeyModel) New(userID int64, name string, permissions Permissions, expiry *time.Time) (*APIKey, error) {
+	key, err := generateAPIKey(userID, name, permissions, expiry)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	query := `
+	INSERT INTO api_keys (hash, prefix, user_id, name, expiry)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id, created_at`
+	args := []interface{}{key.Hash, key.Prefix, key.UserID, key.Name, key.Expiry}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	query = `
+	INSERT INTO api_keys_permissions
+	SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+	_, err = tx.ExecContext(ctx, query, key.ID, pq.Array(key.Permissions))
+	if err != nil {
+		return nil, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// The GetForKey() method looks up an unexpired API key from its plaintext value.
+func (m APIKeyModel) GetForKey(plaintext string) (*APIKey, error) {
+	hash := sha256.Sum256([]byte(plaintext))
+	query := `
+	SELECT api_keys.id, api_keys.prefix, api_keys.user_id, api_keys.name, api_keys.created_at, api_keys.last_used_at, api_keys.expiry,
+		COALESCE(array_agg(permissions.code ORDER BY permissions.code) FILTER (WHERE permissions.code IS NOT NULL), '{}')
+	FROM api_keys
+	LEFT JOIN api_keys_permissions ON api_keys_permissions.api_key_id = api_keys.id
+	LEFT JOIN permissions ON api_keys_permissions.permission_id = permissions.id
+	WHERE api_keys.hash = $1 AND (api_keys.expiry IS NULL OR api_keys.expiry > $2)
+	GROUP BY api_keys.id`
+	var key APIKey
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, hash[:], time.Now()).Scan(
+		&key.ID,
+		&key.Prefix,
+		&key.UserID,
+		&key.Name,
+		&key.CreatedAt,
+		&key.LastUsedAt,
+		&key.Expiry,
+		pq.Array(&key.Permissions),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	key.Hash = hash[:]
+	return &key, nil
+}
+
+// The GetAllForUser() method returns every API key belonging to a user, including
+// expired ones so that the owner can see and tidy them up, most recently created first.
+func (m APIKeyModel) GetAllForUser(userID int64) ([]*APIKey, error) {
+	query := `
+	SELECT api_keys.id, api_keys.prefix, api_keys.user_id, api_keys.name, api_keys.created_at, api_keys.last_used_at, api_keys.expiry,
+		COALESCE(array_agg(permissions.code ORDER BY permissions.code) FILTER (WHERE permissions.code IS NOT NULL), '{}')
+	FROM api_keys
+	LEFT JOIN api_keys_permissions ON api_keys_permissions.api_key_id = api_keys.id
+	LEFT JOIN permissions ON api_keys_permissions.permission_id = permissions.id
+	WHERE api_keys.user_id = $1
+	GROUP BY api_keys.id
+	ORDER BY api_keys.created_at DESC, api_keys.id DESC`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := []*APIKey{}
+	for rows.Next() {
+		var key APIKey
+		err := rows.Scan(
+			&key.ID,
+			&key.Prefix,
+			&key.UserID,
+			&key.Name,
+			&key.CreatedAt,
+			&key.LastUsedAt,
+			&key.Expiry,
+			pq.Array(&key.Permissions),
+		)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, &key)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// UpdateLastUsed() records that a key has just been used. As with authentication
+// tokens, the timestamp is only moved forward if it is more than a minute old.
+func (m APIKeyModel) UpdateLastUsed(id int64) error {
+	query := `
+	UPDATE api_keys
+	SET last_used_at = $1
+	WHERE id = $2 AND (last_used_at IS NULL OR last_used_at < $3)`
+	now := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, now, id, now.Add(-time.Minute))
+	return err
+}
+
+// Delete() revokes a single API key, making sure that it belongs to the given user.
+func (m APIKeyModel) Delete(userID, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `
+	DELETE FROM api_keys
+	WHERE id = $1 AND user_id = $2`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// DeleteAllForUser() revokes every API key belonging to a user.
+func (m APIKeyModel) DeleteAllForUser(userID int64) error {
+	query := `
+	DELETE FROM api_keys
+	WHERE user_id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	return err
+}
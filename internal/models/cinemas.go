@@ -0,0 +1,324 @@
+package models
+
+import (
+	"cinemaGo/pkg/validator"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrDuplicateHall = errors.New("duplicate hall")
+)
+
+// A Cinema is a venue with one or more halls.
+type Cinema struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"-"`
+	Name      string    `json:"name"`
+	Address   string    `json:"address"`
+	City      string    `json:"city"`
+	Version   int32     `json:"version"`
+}
+
+func ValidateCinema(v *validator.Validator, cinema *Cinema) {
+	v.Check(cinema.Name != "", "name", "must be provided")
+	v.Check(len(cinema.Name) <= 500, "name", "must not be more than 500 bytes long")
+	v.Check(cinema.Address != "", "address", "must be provided")
+	v.Check(len(cinema.Address) <= 500, "address", "must not be more than 500 bytes long")
+	v.Check(cinema.City != "", "city", "must be provided")
+	v.Check(len(cinema.City) <= 100, "city", "must not be more than 100 bytes long")
+}
+
+// A Hall is a screen within a cinema, with its own seating layout. Capacity is the
+// number of seats in the layout which aren't blocked.
+type Hall struct {
+	ID        int64         `json:"id"`
+	CreatedAt time.Time     `json:"-"`
+	CinemaID  int64         `json:"cinema_id"`
+	Name      string        `json:"name"`
+	Layout    SeatingLayout `json:"layout"`
+	Capacity  int           `json:"capacity"`
+	Version   int32         `json:"version"`
+}
+
+func ValidateHall(v *validator.Validator, hall *Hall) {
+	v.Check(hall.Name != "", "name", "must be provided")
+	v.Check(len(hall.Name) <= 100, "name", "must not be more than 100 bytes long")
+	ValidateSeatingLayout(v, hall.Layout)
+}
+
+// Define a CinemaModel struct type which wraps a sql.DB connection pool. It manages
+// both cinemas and their halls.
+type CinemaModel struct {
+	DB *sql.DB
+}
+
+func (m CinemaModel) Insert(cinema *Cinema) error {
+	query := `
+	INSERT INTO cinemas (name, address, city)
+	VALUES ($1, $2, $3)
+	RETURNING id, created_at, version`
+	args := []interface{}{cinema.Name, cinema.Address, cinema.City}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&cinema.ID, &cinema.CreatedAt, &cinema.Version)
+}
+
+func (m CinemaModel) Get(id int64) (*Cinema, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+	SELECT id, created_at, name, address, city, version
+	FROM cinemas
+	WHERE id = $1`
+	var cinema Cinema
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&cinema.ID,
+		&cinema.CreatedAt,
+		&cinema.Name,
+		&cinema.Address,
+		&cinema.City,
+		&cinema.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &cinema, nil
+}
+
+func (m CinemaModel) Update(cinema *Cinema) error {
+	query := `
+	UPDATE cinemas
+	SET name = $1, address = $2, city = $3, version = version + 1
+	WHERE id = $4 AND version = $5
+	RETURNING version`
+	args := []interface{}{cinema.Name, cinema.Address, cinema.City, cinema.ID, cinema.Version}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&cinema.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// The Delete() method removes a cinema. Its halls are removed along with it by ON
+// DELETE CASCADE.
+func (m CinemaModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `
+	DELETE FROM cinemas
+	WHERE id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// The GetAll() method returns a page of cinemas, optionally filtered by a full-text
+// search on their name and by city.
+func (m CinemaModel) GetAll(name, city string, filters Filters) ([]*Cinema, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), id, created_at, name, address, city, version
+	FROM cinemas
+	WHERE (to_tsvector('simple', name) @@ plainto_tsquery('simple', $1) OR $1 = '')
+	AND (lower(city) = lower($2) OR $2 = '')
+	ORDER BY %s %s, id ASC
+	LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, name, city, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	cinemas := []*Cinema{}
+	for rows.Next() {
+		var cinema Cinema
+		err := rows.Scan(
+			&totalRecords,
+			&cinema.ID,
+			&cinema.CreatedAt,
+			&cinema.Name,
+			&cinema.Address,
+			&cinema.City,
+			&cinema.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		cinemas = append(cinemas, &cinema)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return cinemas, metadata, nil
+}
+
+// The InsertHall() method adds a hall to a cinema. Hall names must be unique within a
+// cinema; if the name is already taken we return an ErrDuplicateHall error.
+func (m CinemaModel) InsertHall(hall *Hall) error {
+	query := `
+	INSERT INTO halls (cinema_id, name, layout)
+	VALUES ($1, $2, $3)
+	RETURNING id, created_at, version`
+	args := []interface{}{hall.CinemaID, hall.Name, hall.Layout}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&hall.ID, &hall.CreatedAt, &hall.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "halls_cinema_name_key"`:
+			return ErrDuplicateHall
+		default:
+			return err
+		}
+	}
+	hall.Capacity = hall.Layout.Capacity()
+	return nil
+}
+
+func (m CinemaModel) GetHall(id int64) (*Hall, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+	SELECT id, created_at, cinema_id, name, layout, version
+	FROM halls
+	WHERE id = $1`
+	var hall Hall
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&hall.ID,
+		&hall.CreatedAt,
+		&hall.CinemaID,
+		&hall.Name,
+		&hall.Layout,
+		&hall.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	hall.Capacity = hall.Layout.Capacity()
+	return &hall, nil
+}
+
+func (m CinemaModel) UpdateHall(hall *Hall) error {
+	query := `
+	UPDATE halls
+	SET name = $1, layout = $2, version = version + 1
+	WHERE id = $3 AND version = $4
+	RETURNING version`
+	args := []interface{}{hall.Name, hall.Layout, hall.ID, hall.Version}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&hall.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		case err.Error() == `pq: duplicate key value violates unique constraint "halls_cinema_name_key"`:
+			return ErrDuplicateHall
+		default:
+			return err
+		}
+	}
+	hall.Capacity = hall.Layout.Capacity()
+	return nil
+}
+
+func (m CinemaModel) DeleteHall(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `
+	DELETE FROM halls
+	WHERE id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// The GetAllHalls() method returns every hall in a cinema, ordered by name.
+func (m CinemaModel) GetAllHalls(cinemaID int64) ([]*Hall, error) {
+	query := `
+	SELECT id, created_at, cinema_id, name, layout, version
+	FROM halls
+	WHERE cinema_id = $1
+	ORDER BY name, id`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, cinemaID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	halls := []*Hall{}
+	for rows.Next() {
+		var hall Hall
+		err := rows.Scan(
+			&hall.ID,
+			&hall.CreatedAt,
+			&hall.CinemaID,
+			&hall.Name,
+			&hall.Layout,
+			&hall.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		hall.Capacity = hall.Layout.Capacity()
+		halls = append(halls, &hall)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return halls, nil
+}
@@ -0,0 +1,566 @@
+package models
+
+import (
+	"cinemaGo/pkg/validator"
+	"cinemaGo/pkg/wordfilter"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Replies can be nested up to CommentMaxDepth levels below a top-level comment, which
+// has a depth of zero. Authors can edit their comments for CommentEditWindow after
+// posting them.
+const (
+	CommentMaxDepth   = 4
+	CommentEditWindow = 15 * time.Minute
+)
+
+// The actions that a moderator can take on a comment.
+const (
+	CommentActionHide    = "hide"
+	CommentActionRestore = "restore"
+)
+
+var (
+	ErrDuplicateReport = errors.New("duplicate report")
+)
+
+// A Comment is part of the discussion of a movie. Top-level comments have no ParentID;
+// replies have the ID of the comment that they reply to.
+//
+// Comments are never removed from a thread while they have replies. Instead, comments
+// deleted by their author, or whose author's account has been deleted, have DeletedAt
+// set, and comments hidden by a moderator have HiddenAt and HiddenBy set, and either
+// way their contents are redacted before they are shown to other users.
+type Comment struct {
+	ID        int64      `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	MovieID   int64      `json:"movie_id"`
+	UserID    int64      `json:"user_id,omitempty"`
+	UserName  string     `json:"user_name,omitempty"`
+	ParentID  *int64     `json:"parent_id,omitempty"`
+	Depth     int32      `json:"depth"`
+	Body      string     `json:"body"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	HiddenAt  *time.Time `json:"hidden_at,omitempty"`
+	HiddenBy  *int64     `json:"hidden_by,omitempty"`
+	Version   int32      `json:"version"`
+	// Replies is only filled in when comments are listed as threads.
+	Replies []*Comment `json:"replies,omitempty"`
+}
+
+func (c *Comment) IsDeleted() bool {
+	return c.DeletedAt != nil
+}
+
+func (c *Comment) IsHidden() bool {
+	return c.HiddenAt != nil
+}
+
+// The Editable() method reports whether the comment's author can still edit it.
+func (c *Comment) Editable(now time.Time) bool {
+	return !c.IsDeleted() && !c.IsHidden() && now.Before(c.CreatedAt.Add(CommentEditWindow))
+}
+
+// The Redact() method removes the contents of a deleted or hidden comment, leaving a
+// placeholder which keeps its replies in place. Deleted comments don't show who wrote
+// them either, and which moderator hid a comment is only shown to other moderators.
+func (c *Comment) Redact() {
+	if c.IsDeleted() {
+		c.Body = ""
+		c.UserID = 0
+		c.UserName = ""
+	}
+	if c.IsHidden() {
+		c.Body = ""
+	}
+	c.HiddenBy = nil
+}
+
+func ValidateComment(v *validator.Validator, comment *Comment) {
+	v.Check(comment.Body != "", "body", "must be provided")
+	v.Check(len(comment.Body) <= 5_000, "body", "must not be more than 5000 bytes long")
+	v.Check(comment.Depth <= CommentMaxDepth, "parent_id", fmt.Sprintf("replies must not be nested more than %d levels deep", CommentMaxDepth))
+}
+
+// A CommentFlag marks a comment for a moderator to look at. Flags are raised by users
+// reporting a comment, in which case UserID is the reporter, or automatically by the
+// word filter, in which case UserID is nil.
+type CommentFlag struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	CommentID int64     `json:"-"`
+	UserID    *int64    `json:"user_id,omitempty"`
+	Reason    string    `json:"reason"`
+}
+
+func ValidateCommentFlag(v *validator.Validator, flag *CommentFlag) {
+	v.Check(flag.Reason != "", "reason", "must be provided")
+	v.Check(len(flag.Reason) <= 500, "reason", "must not be more than 500 bytes long")
+}
+
+// A FlaggedComment is an entry in the moderation queue: a comment along with the flags
+// on it which haven't been dealt with yet.
+type FlaggedComment struct {
+	*Comment
+	Flags []*CommentFlag `json:"flags"`
+}
+
+// A CommentModerationAction records a moderator hiding or restoring a comment. The
+// ModeratorID is nil if the moderator's account has since been deleted.
+type CommentModerationAction struct {
+	ID          int64     `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	CommentID   int64     `json:"comment_id"`
+	ModeratorID *int64    `json:"moderator_id"`
+	Action      string    `json:"action"`
+	Reason      string    `json:"reason,omitempty"`
+}
+
+// Define the CommentModel type. The Filter is used to flag comments automatically when
+// they are posted or edited, and may be nil.
+type CommentModel struct {
+	DB     *sql.DB
+	Filter wordfilter.Filter
+}
+
+// The columns selected for a comment by every query, which must be scanned with
+// commentDest(). The user_id is NULL once the author's account has been deleted, so
+// users is always LEFT JOINed.
+const commentColumns = `comments.id, comments.created_at, comments.updated_at, comments.movie_id,
+	COALESCE(comments.user_id, 0), COALESCE(users.name, ''), comments.parent_id, comments.depth, comments.body,
+	comments.deleted_at, comments.hidden_at, comments.hidden_by, comments.version`
+
+func commentDest(comment *Comment) []interface{} {
+	return []interface{}{
+		&comment.ID,
+		&comment.CreatedAt,
+		&comment.UpdatedAt,
+		&comment.MovieID,
+		&comment.UserID,
+		&comment.UserName,
+		&comment.ParentID,
+		&comment.Depth,
+		&comment.Body,
+		&comment.DeletedAt,
+		&comment.HiddenAt,
+		&comment.HiddenBy,
+		&comment.Version,
+	}
+}
+
+// The autoFlag() helper runs the comment body through the filter, and flags the
+// comment if it matches. It is called in the same transaction as the insert or update,
+// so that a comment is never visible without the flag.
+func (m CommentModel) autoFlag(ctx context.Context, tx *sql.Tx, comment *Comment) error {
+	if m.Filter == nil {
+		return nil
+	}
+	reason, flagged := m.Filter.Check(comment.Body)
+	if !flagged {
+		return nil
+	}
+	query := `
+	INSERT INTO comment_flags (comment_id, reason)
+	VALUES ($1, $2)`
+	_, err := tx.ExecContext(ctx, query, comment.ID, "automatic: "+reason)
+	return err
+}
+
+// The Insert() method adds a comment, flagging it for moderation if it matches the
+// filter.
+func (m CommentModel) Insert(comment *Comment) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	query := `
+	INSERT INTO comments (movie_id, user_id, parent_id, depth, body)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id, created_at, updated_at, version,
+		(SELECT name FROM users WHERE users.id = user_id)`
+	args := []interface{}{comment.MovieID, comment.UserID, comment.ParentID, comment.Depth, comment.Body}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&comment.ID, &comment.CreatedAt, &comment.UpdatedAt, &comment.Version, &comment.UserName)
+	if err != nil {
+		return err
+	}
+	err = m.autoFlag(ctx, tx, comment)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// The Get() method returns a single comment, without redacting it.
+func (m CommentModel) Get(id int64) (*Comment, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+	SELECT ` + commentColumns + `
+	FROM comments
+	LEFT JOIN users ON users.id = comments.user_id
+	WHERE comments.id = $1`
+	var comment Comment
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(commentDest(&comment)...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &comment, nil
+}
+
+// The Update() method changes the body of a comment, using the version number to
+// detect edit conflicts. The new body is checked against the filter, just like a new
+// comment.
+func (m CommentModel) Update(comment *Comment) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	query := `
+	UPDATE comments
+	SET body = $1, updated_at = NOW(), version = version + 1
+	WHERE id = $2 AND version = $3
+	RETURNING updated_at, version`
+	err = tx.QueryRowContext(ctx, query, comment.Body, comment.ID, comment.Version).Scan(&comment.UpdatedAt, &comment.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	err = m.autoFlag(ctx, tx, comment)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// The Delete() method soft deletes a comment, leaving it in place so that the replies
+// to it still make sense. We return ErrRecordNotFound if the comment doesn't exist or
+// has already been deleted.
+func (m CommentModel) Delete(id int64) error {
+	query := `
+	UPDATE comments
+	SET deleted_at = NOW(), version = version + 1
+	WHERE id = $1 AND deleted_at IS NULL`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// The GetThreadsForMovie() method returns a page of top-level comments for a movie,
+// each with all of its replies nested underneath it in the order they were posted.
+// Pagination applies to the top-level comments only. Deleted and hidden comments are
+// redacted.
+func (m CommentModel) GetThreadsForMovie(movieID int64, filters Filters) ([]*Comment, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), `+commentColumns+`
+	FROM comments
+	LEFT JOIN users ON users.id = comments.user_id
+	WHERE comments.movie_id = $1 AND comments.parent_id IS NULL
+	ORDER BY comments.%s %s, comments.id ASC
+	LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	threads := []*Comment{}
+	byID := make(map[int64]*Comment)
+	var ids []int64
+	for rows.Next() {
+		var comment Comment
+		err := rows.Scan(append([]interface{}{&totalRecords}, commentDest(&comment)...)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		threads = append(threads, &comment)
+		byID[comment.ID] = &comment
+		ids = append(ids, comment.ID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	// Fetch every reply below the comments on this page. Replies are ordered by the time
+	// they were posted, so a reply's parent is always seen before the reply itself.
+	query = `
+	WITH RECURSIVE thread AS (
+		SELECT id FROM comments WHERE parent_id = ANY($1)
+		UNION ALL
+		SELECT comments.id FROM comments INNER JOIN thread ON comments.parent_id = thread.id
+	)
+	SELECT ` + commentColumns + `
+	FROM comments
+	LEFT JOIN users ON users.id = comments.user_id
+	WHERE comments.id IN (SELECT id FROM thread)
+	ORDER BY comments.created_at, comments.id`
+	replies, err := m.DB.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer replies.Close()
+	for replies.Next() {
+		var comment Comment
+		err := replies.Scan(commentDest(&comment)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		byID[comment.ID] = &comment
+		if parent, found := byID[*comment.ParentID]; found {
+			parent.Replies = append(parent.Replies, &comment)
+		}
+	}
+	if err = replies.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	for _, comment := range byID {
+		comment.Redact()
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return threads, metadata, nil
+}
+
+// The GetAllForUser() method returns every comment written by a user, most recent
+// first. It is used when exporting the user's data.
+func (m CommentModel) GetAllForUser(userID int64) ([]*Comment, error) {
+	query := `
+	SELECT ` + commentColumns + `
+	FROM comments
+	LEFT JOIN users ON users.id = comments.user_id
+	WHERE comments.user_id = $1
+	ORDER BY comments.created_at DESC, comments.id DESC`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	comments := []*Comment{}
+	for rows.Next() {
+		var comment Comment
+		err := rows.Scan(commentDest(&comment)...)
+		if err != nil {
+			return nil, err
+		}
+		comment.HiddenBy = nil
+		comments = append(comments, &comment)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return comments, nil
+}
+
+// The InsertFlag() method records a user's report of a comment. Each user can only
+// report a comment once; if they try again we return an ErrDuplicateReport error.
+func (m CommentModel) InsertFlag(flag *CommentFlag) error {
+	query := `
+	INSERT INTO comment_flags (comment_id, user_id, reason)
+	VALUES ($1, $2, $3)
+	RETURNING id, created_at`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, flag.CommentID, flag.UserID, flag.Reason).Scan(&flag.ID, &flag.CreatedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "comment_flags_comment_user_key"`:
+			return ErrDuplicateReport
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// The GetModerationQueue() method returns a page of comments with unresolved flags,
+// along with those flags. Comments which have since been deleted by their author don't
+// need moderating, so they are left out.
+func (m CommentModel) GetModerationQueue(filters Filters) ([]*FlaggedComment, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), `+commentColumns+`
+	FROM comments
+	LEFT JOIN users ON users.id = comments.user_id
+	INNER JOIN (
+		SELECT comment_id, count(*) AS flag_count, min(created_at) AS flagged_at
+		FROM comment_flags
+		WHERE resolved_at IS NULL
+		GROUP BY comment_id
+	) AS flags ON flags.comment_id = comments.id
+	WHERE comments.deleted_at IS NULL
+	ORDER BY %s %s, comments.id ASC
+	LIMIT $1 OFFSET $2`, filters.sortColumn(), filters.sortDirection())
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	queue := []*FlaggedComment{}
+	byID := make(map[int64]*FlaggedComment)
+	var ids []int64
+	for rows.Next() {
+		entry := &FlaggedComment{Comment: &Comment{}, Flags: []*CommentFlag{}}
+		err := rows.Scan(append([]interface{}{&totalRecords}, commentDest(entry.Comment)...)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		queue = append(queue, entry)
+		byID[entry.ID] = entry
+		ids = append(ids, entry.ID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	query = `
+	SELECT id, created_at, comment_id, user_id, reason
+	FROM comment_flags
+	WHERE resolved_at IS NULL AND comment_id = ANY($1)
+	ORDER BY created_at, id`
+	flags, err := m.DB.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer flags.Close()
+	for flags.Next() {
+		var flag CommentFlag
+		err := flags.Scan(&flag.ID, &flag.CreatedAt, &flag.CommentID, &flag.UserID, &flag.Reason)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		byID[flag.CommentID].Flags = append(byID[flag.CommentID].Flags, &flag)
+	}
+	if err = flags.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return queue, metadata, nil
+}
+
+// The Hide() method hides a comment from other users, and the Restore() method makes a
+// hidden comment visible again. Restoring a comment which isn't hidden dismisses the
+// flags on it. Either way, the comment's outstanding flags are resolved and the action
+// is recorded along with the moderator's ID, all in a single transaction.
+func (m CommentModel) Hide(comment *Comment, moderatorID int64, reason string) error {
+	query := `
+	UPDATE comments
+	SET hidden_at = NOW(), hidden_by = $1, version = version + 1
+	WHERE id = $2 AND version = $3
+	RETURNING hidden_at, hidden_by, version`
+	return m.moderate(comment, query, []interface{}{moderatorID, comment.ID, comment.Version}, moderatorID, CommentActionHide, reason)
+}
+
+func (m CommentModel) Restore(comment *Comment, moderatorID int64, reason string) error {
+	query := `
+	UPDATE comments
+	SET hidden_at = NULL, hidden_by = NULL, version = version + 1
+	WHERE id = $1 AND version = $2
+	RETURNING hidden_at, hidden_by, version`
+	return m.moderate(comment, query, []interface{}{comment.ID, comment.Version}, moderatorID, CommentActionRestore, reason)
+}
+
+func (m CommentModel) moderate(comment *Comment, query string, args []interface{}, moderatorID int64, action, reason string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&comment.HiddenAt, &comment.HiddenBy, &comment.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE comment_flags SET resolved_at = NOW() WHERE comment_id = $1 AND resolved_at IS NULL`, comment.ID)
+	if err != nil {
+		return err
+	}
+	query = `
+	INSERT INTO comment_moderation_actions (comment_id, moderator_id, action, reason)
+	VALUES ($1, $2, $3, $4)`
+	_, err = tx.ExecContext(ctx, query, comment.ID, moderatorID, action, reason)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// The GetModerationActions() method returns the history of moderation actions taken
+// on a comment, oldest first.
+func (m CommentModel) GetModerationActions(commentID int64) ([]*CommentModerationAction, error) {
+	query := `
+	SELECT id, created_at, comment_id, moderator_id, action, reason
+	FROM comment_moderation_actions
+	WHERE comment_id = $1
+	ORDER BY created_at, id`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, commentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	actions := []*CommentModerationAction{}
+	for rows.Next() {
+		var action CommentModerationAction
+		err := rows.Scan(
+			&action.ID,
+			&action.CreatedAt,
+			&action.CommentID,
+			&action.ModeratorID,
+			&action.Action,
+			&action.Reason,
+		)
+		if err != nil {
+			return nil, err
+		}
+		actions = append(actions, &action)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return actions, nil
+}
@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 type Config struct {
 	Port int
 	Env  string
@@ -18,4 +20,31 @@ type Config struct {
 		Password string
 		Sender   string
 	}
+	Permissions struct {
+		CacheTTL time.Duration
+	}
+	Tokens struct {
+		AccessTTL  time.Duration
+		RefreshTTL time.Duration
+		// Format is either TokenFormatOpaque or TokenFormatJWT. In JWT mode the keys
+		// are given as "id:algorithm:base64-key", and new tokens are signed with the
+		// key named by SigningKey (or the first key, if that is empty).
+		Format     string
+		Keys       []string
+		SigningKey string
+	}
+	// Comments.WordList is the path of a file listing words which cause comments to
+	// be flagged for moderation, one per line. It may be empty.
+	Comments struct {
+		WordList string
+	}
+	// Oidc configures sign-in with an external OpenID Connect identity provider. It is
+	// disabled if Issuer is empty. RedirectURI is the page of our web client which the
+	// provider sends users back to, and must be registered with the provider.
+	Oidc struct {
+		Issuer       string
+		ClientID     string
+		ClientSecret string
+		RedirectURI  string
+	}
 }
@@ -0,0 +1,221 @@
+package models
+
+import (
+	"cinemaGo/pkg/validator"
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	ErrDuplicateCredit = errors.New("duplicate credit")
+)
+
+// The roles that a person can be credited with on a movie.
+const (
+	CreditRoleDirector = "director"
+	CreditRoleActor    = "actor"
+	CreditRoleWriter   = "writer"
+	CreditRoleComposer = "composer"
+)
+
+var CreditRoles = []string{CreditRoleDirector, CreditRoleActor, CreditRoleWriter, CreditRoleComposer}
+
+// A Credit records that a person worked on a movie in a particular role. Actors can be
+// credited with the Character that they played, and credits are listed in ascending
+// BillingOrder. The MovieTitle and PersonName are read from the movies and people
+// tables, so that clients don't need to look them up separately.
+type Credit struct {
+	ID           int64  `json:"id"`
+	MovieID      int64  `json:"movie_id"`
+	MovieTitle   string `json:"movie_title"`
+	PersonID     int64  `json:"person_id"`
+	PersonName   string `json:"person_name"`
+	Role         string `json:"role"`
+	Character    string `json:"character,omitempty"`
+	BillingOrder int32  `json:"billing_order"`
+}
+
+func ValidateCredit(v *validator.Validator, credit *Credit) {
+	v.Check(credit.PersonID > 0, "person_id", "must be provided")
+	v.Check(credit.Role != "", "role", "must be provided")
+	v.Check(validator.In(credit.Role, CreditRoles...), "role", "must be one of director, actor, writer or composer")
+	if credit.Role != CreditRoleActor {
+		v.Check(credit.Character == "", "character", "must only be provided for actors")
+	}
+	v.Check(len(credit.Character) <= 500, "character", "must not be more than 500 bytes long")
+	v.Check(credit.BillingOrder >= 0, "billing_order", "must not be negative")
+}
+
+// Define a CreditModel struct type which wraps a sql.DB connection pool.
+type CreditModel struct {
+	DB *sql.DB
+}
+
+// The Insert() method adds a credit to a movie. If the person already has the same
+// credit on the movie we return an ErrDuplicateCredit error.
+func (m CreditModel) Insert(credit *Credit) error {
+	query := `
+	INSERT INTO movie_credits (movie_id, person_id, role, character, billing_order)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id,
+		(SELECT title FROM movies WHERE movies.id = movie_id),
+		(SELECT name FROM people WHERE people.id = person_id)`
+	args := []interface{}{credit.MovieID, credit.PersonID, credit.Role, credit.Character, credit.BillingOrder}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&credit.ID, &credit.MovieTitle, &credit.PersonName)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "movie_credits_unique"`:
+			return ErrDuplicateCredit
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m CreditModel) Get(id int64) (*Credit, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+	SELECT movie_credits.id, movie_id, movies.title, person_id, people.name, role, character, billing_order
+	FROM movie_credits
+	INNER JOIN movies ON movies.id = movie_credits.movie_id
+	INNER JOIN people ON people.id = movie_credits.person_id
+	WHERE movie_credits.id = $1`
+	var credit Credit
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&credit.ID,
+		&credit.MovieID,
+		&credit.MovieTitle,
+		&credit.PersonID,
+		&credit.PersonName,
+		&credit.Role,
+		&credit.Character,
+		&credit.BillingOrder,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &credit, nil
+}
+
+// The Update() method changes the role, character and billing order of a credit. The
+// movie and person can't be changed; delete the credit and add a new one instead.
+func (m CreditModel) Update(credit *Credit) error {
+	query := `
+	UPDATE movie_credits
+	SET role = $1, character = $2, billing_order = $3
+	WHERE id = $4`
+	args := []interface{}{credit.Role, credit.Character, credit.BillingOrder, credit.ID}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "movie_credits_unique"`:
+			return ErrDuplicateCredit
+		default:
+			return err
+		}
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func (m CreditModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `
+	DELETE FROM movie_credits
+	WHERE id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// The GetAllForMovie() method returns a movie's credits in billing order.
+func (m CreditModel) GetAllForMovie(movieID int64) ([]*Credit, error) {
+	query := `
+	SELECT movie_credits.id, movie_id, movies.title, person_id, people.name, role, character, billing_order
+	FROM movie_credits
+	INNER JOIN movies ON movies.id = movie_credits.movie_id
+	INNER JOIN people ON people.id = movie_credits.person_id
+	WHERE movie_id = $1
+	ORDER BY billing_order, movie_credits.id`
+	return m.query(query, movieID)
+}
+
+// The GetAllForPerson() method returns a person's credits, with the most recent movies
+// first.
+func (m CreditModel) GetAllForPerson(personID int64) ([]*Credit, error) {
+	query := `
+	SELECT movie_credits.id, movie_id, movies.title, person_id, people.name, role, character, billing_order
+	FROM movie_credits
+	INNER JOIN movies ON movies.id = movie_credits.movie_id
+	INNER JOIN people ON people.id = movie_credits.person_id
+	WHERE person_id = $1
+	ORDER BY movies.year DESC, movies.id DESC, billing_order, movie_credits.id`
+	return m.query(query, personID)
+}
+
+func (m CreditModel) query(query string, args ...interface{}) ([]*Credit, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	credits := []*Credit{}
+	for rows.Next() {
+		var credit Credit
+		err := rows.Scan(
+			&credit.ID,
+			&credit.MovieID,
+			&credit.MovieTitle,
+			&credit.PersonID,
+			&credit.PersonName,
+			&credit.Role,
+			&credit.Character,
+			&credit.BillingOrder,
+		)
+		if err != nil {
+			return nil, err
+		}
+		credits = append(credits, &credit)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return credits, nil
+}
@@ -2,7 +2,10 @@ package models
 
 import (
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 // The logError() method is a generic helper for logging an error message. Later in the
@@ -80,6 +83,15 @@ func (app *Application) invalidCredentialsResponse(w http.ResponseWriter, r *htt
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 }
 
+// The tooManyLoginAttemptsResponse() method is used when login attempts are being
+// throttled after repeated failures. The Retry-After header tells the client how many
+// seconds to wait before trying again.
+func (app *Application) tooManyLoginAttemptsResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	message := "too many failed login attempts, please try again later"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
 func (app *Application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("WWW-Authenticate", "Bearer")
 	message := "invalid or missing authentication token"
@@ -95,7 +107,47 @@ func (app *Application) inactiveAccountResponse(w http.ResponseWriter, r *http.R
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
 
+// The accountSuspendedResponse() method is used when a suspended user tries to
+// authenticate. We include the reason and end time (if any) so that clients can show
+// the user why they have been locked out.
+func (app *Application) accountSuspendedResponse(w http.ResponseWriter, r *http.Request, user *User) {
+	message := map[string]interface{}{
+		"message": "your user account has been suspended",
+		"reason":  user.SuspensionReason,
+	}
+	if user.SuspendedUntil != nil {
+		message["until"] = user.SuspendedUntil
+	}
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
 func (app *Application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
 	message := "your user account doesn't have the necessary permissions to access this resource"
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
+
+// The sessionRequiredResponse() method is used when an API key or OAuth client token is
+// presented to an endpoint which manages the account itself, and so needs the user's
+// own login session.
+func (app *Application) sessionRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this resource can't be accessed using an API key or third-party application token"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// The oauthErrorResponse() method sends an error from the OAuth token endpoint. These
+// use the format from RFC 6749 section 5.2 rather than our usual one, so that standard
+// OAuth client libraries can understand them. The code is one of the error codes
+// defined by the RFC, such as "invalid_grant".
+func (app *Application) oauthErrorResponse(w http.ResponseWriter, r *http.Request, status int, code, description string) {
+	env := envelope{"error": code, "error_description": description}
+	headers := make(http.Header)
+	headers.Set("Cache-Control", "no-store")
+	if status == http.StatusUnauthorized {
+		headers.Set("WWW-Authenticate", "Basic")
+	}
+	err := app.writeJSON(w, status, env, headers)
+	if err != nil {
+		app.logError(r, err)
+		w.WriteHeader(500)
+	}
+}
@@ -0,0 +1,194 @@
+package models
+
+import (
+	"cinemaGo/pkg/jwt"
+	"context"
+	"database/sql"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The token formats that can be selected with the -token-format flag. Opaque tokens are
+// random strings which are looked up in the tokens table on every request. JWTs are
+// signed and can be checked without touching the tokens table at all.
+const (
+	TokenFormatOpaque = "opaque"
+	TokenFormatJWT    = "jwt"
+)
+
+// The issuer recorded in, and required of, every JWT that we sign.
+const jwtIssuer = "cinemago"
+
+// The revocationList type holds the IDs of sessions which have been logged out, along
+// with the expiry of their authentication tokens. A revoked JWT only needs to be
+// remembered until it would have expired anyway, so the list stays small.
+type revocationList struct {
+	mu      sync.RWMutex
+	entries map[int64]time.Time
+}
+
+func newRevocationList() *revocationList {
+	return &revocationList{entries: make(map[int64]time.Time)}
+}
+
+func (l *revocationList) add(id int64, expiry time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries[id] = expiry
+}
+
+func (l *revocationList) contains(id int64) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	_, found := l.entries[id]
+	return found
+}
+
+// The merge() method adds entries loaded from the database, and drops any entries
+// which have expired. Entries are never removed otherwise, so one added by this
+// instance while the database was being read can't be lost.
+func (l *revocationList) merge(entries map[int64]time.Time, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for id, expiry := range l.entries {
+		if !expiry.After(now) {
+			delete(l.entries, id)
+		}
+	}
+	for id, expiry := range entries {
+		l.entries[id] = expiry
+	}
+}
+
+// Define the JWTModel type. Each JWT refers to a session in the tokens table by its
+// session ID, using the jti claim, so that sessions can still be listed and revoked in
+// the usual way. Revoked session IDs are stored in the revoked_tokens table and kept in memory,
+// so that checking them doesn't need a database query.
+type JWTModel struct {
+	DB      *sql.DB
+	Keys    *jwt.KeySet
+	revoked *revocationList
+}
+
+// The New() method returns a copy of a session's authentication token with its
+// plaintext replaced by a signed JWT.
+func (m JWTModel) New(session *Token) (*Token, error) {
+	claims := jwt.Claims{
+		Issuer:   jwtIssuer,
+		Subject:  strconv.FormatInt(session.UserID, 10),
+		ID:       strconv.FormatInt(session.SessionID, 10),
+		IssuedAt: session.CreatedAt.Unix(),
+		Expiry:   session.Expiry.Unix(),
+	}
+	plaintext, err := m.Keys.Sign(claims)
+	if err != nil {
+		return nil, err
+	}
+	token := *session
+	token.Plaintext = plaintext
+	return &token, nil
+}
+
+// The Verify() method checks a JWT and returns the IDs of the user and session that it
+// belongs to. As with GetForToken(), we return ErrRecordNotFound for any token which
+// isn't acceptable, whatever the reason.
+func (m JWTModel) Verify(plaintext string) (int64, int64, error) {
+	claims, err := m.Keys.Verify(plaintext, time.Now())
+	if err != nil || claims.Issuer != jwtIssuer {
+		return 0, 0, ErrRecordNotFound
+	}
+	userID, err := strconv.ParseInt(claims.Subject, 10, 64)
+	if err != nil {
+		return 0, 0, ErrRecordNotFound
+	}
+	sessionID, err := strconv.ParseInt(claims.ID, 10, 64)
+	if err != nil {
+		return 0, 0, ErrRecordNotFound
+	}
+	if m.revoked.contains(sessionID) {
+		return 0, 0, ErrRecordNotFound
+	}
+	return userID, sessionID, nil
+}
+
+// The RevokeSession() method adds a single session belonging to a user to the
+// revocation list. This must be called before the session is deleted from the tokens
+// table, as that is where the expiry is read from.
+func (m JWTModel) RevokeSession(userID, id int64) error {
+	query := `
+	INSERT INTO revoked_tokens (session_id, expiry)
+	SELECT COALESCE(session_id, id), max(expiry) FROM tokens
+	WHERE COALESCE(session_id, id) = $1 AND user_id = $2 AND scope = $3 AND expiry > $4
+	GROUP BY 1
+	ON CONFLICT DO NOTHING
+	RETURNING session_id, expiry`
+	return m.insertRevoked(query, id, userID, ScopeAuthentication, time.Now())
+}
+
+// The RevokeSessionsForUser() method adds every session belonging to a user to the
+// revocation list, apart from the session with ID exceptID (pass 0 to revoke them all).
+func (m JWTModel) RevokeSessionsForUser(userID, exceptID int64) error {
+	query := `
+	INSERT INTO revoked_tokens (session_id, expiry)
+	SELECT COALESCE(session_id, id), max(expiry) FROM tokens
+	WHERE user_id = $1 AND COALESCE(session_id, id) <> $2 AND scope = $3 AND expiry > $4
+	GROUP BY 1
+	ON CONFLICT DO NOTHING
+	RETURNING session_id, expiry`
+	return m.insertRevoked(query, userID, exceptID, ScopeAuthentication, time.Now())
+}
+
+func (m JWTModel) insertRevoked(query string, args ...interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var expiry time.Time
+		err := rows.Scan(&id, &expiry)
+		if err != nil {
+			return err
+		}
+		m.revoked.add(id, expiry)
+	}
+	return rows.Err()
+}
+
+// The LoadRevoked() method updates the in-memory revocation list from the
+// revoked_tokens table, after clearing out entries which have expired. We call this
+// at startup and then periodically, so that sessions revoked by other instances of the
+// application are picked up.
+func (m JWTModel) LoadRevoked() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	now := time.Now()
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM revoked_tokens WHERE expiry <= $1`, now)
+	if err != nil {
+		return err
+	}
+	rows, err := m.DB.QueryContext(ctx, `SELECT session_id, expiry FROM revoked_tokens`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	entries := make(map[int64]time.Time)
+	for rows.Next() {
+		var id int64
+		var expiry time.Time
+		err := rows.Scan(&id, &expiry)
+		if err != nil {
+			return err
+		}
+		entries[id] = expiry
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	m.revoked.merge(entries, now)
+	return nil
+}
@@ -0,0 +1,169 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// The limits used to slow down password guessing. Failed attempts are counted over
+// the LoginAttemptWindow, both for the email address being tried and for the IP
+// address making the request. After a few failures each further attempt has to wait
+// for an exponentially increasing delay, and once the lockout threshold is reached
+// attempts are refused until LoginLockoutDuration after the last failure.
+const (
+	LoginAttemptWindow   = 15 * time.Minute
+	LoginLockoutDuration = 15 * time.Minute
+	LoginMaxDelay        = time.Minute
+
+	LoginAccountDelayThreshold   = 3
+	LoginAccountLockoutThreshold = 10
+	LoginIPDelayThreshold        = 20
+	LoginIPLockoutThreshold      = 100
+)
+
+// LoginAttemptStatus holds the recent failed login attempts for an email address and
+// for an IP address.
+type LoginAttemptStatus struct {
+	AccountFailures    int
+	AccountLastFailure time.Time
+	IPFailures         int
+	IPLastFailure      time.Time
+}
+
+// The loginWait() function returns how long after the last failure the next attempt
+// must wait, given the number of recent failures and the thresholds that apply.
+func loginWait(failures, delayThreshold, lockoutThreshold int) time.Duration {
+	switch {
+	case failures >= lockoutThreshold:
+		return LoginLockoutDuration
+	case failures >= delayThreshold:
+		delay := time.Second << (failures - delayThreshold)
+		if delay > LoginMaxDelay {
+			delay = LoginMaxDelay
+		}
+		return delay
+	default:
+		return 0
+	}
+}
+
+// The RetryAfter() method returns how long the client must wait before another login
+// attempt will be accepted, or zero if it may try again straight away. We use the
+// larger of the account and IP address waits.
+func (s LoginAttemptStatus) RetryAfter(now time.Time) time.Duration {
+	var retryAfter time.Duration
+	wait := loginWait(s.AccountFailures, LoginAccountDelayThreshold, LoginAccountLockoutThreshold)
+	if remaining := s.AccountLastFailure.Add(wait).Sub(now); wait > 0 && remaining > retryAfter {
+		retryAfter = remaining
+	}
+	wait = loginWait(s.IPFailures, LoginIPDelayThreshold, LoginIPLockoutThreshold)
+	if remaining := s.IPLastFailure.Add(wait).Sub(now); wait > 0 && remaining > retryAfter {
+		retryAfter = remaining
+	}
+	return retryAfter
+}
+
+// The AccountLocked() method reports whether the email address has reached the lockout
+// threshold.
+func (s LoginAttemptStatus) AccountLocked() bool {
+	return s.AccountFailures >= LoginAccountLockoutThreshold
+}
+
+// Define the LoginAttemptModel type.
+type LoginAttemptModel struct {
+	DB *sql.DB
+}
+
+// The Begin() method starts a login attempt for an email address from an IP address.
+// If there have been too many recent failures it records nothing and returns how long
+// the client must wait. Otherwise it records the attempt as a failure straight away and
+// returns zero; if the login then succeeds, DeleteAllForEmail() clears it again. The
+// check and the insert happen in one transaction holding an advisory lock on the email
+// address, so concurrent guesses for the same account can't all get in under the
+// threshold together. Attempts which are too old to count any more are cleared out at
+// the same time, so that the table doesn't grow without limit.
+func (m LoginAttemptModel) Begin(email, ip string) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	// The email column is citext, so lock on the lowercased address.
+	_, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext(lower($1)))`, email)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	query := `
+	DELETE FROM login_attempts
+	WHERE created_at < $1`
+	_, err = tx.ExecContext(ctx, query, now.Add(-LoginAttemptWindow))
+	if err != nil {
+		return 0, err
+	}
+	status, err := scanLoginAttemptStatus(tx.QueryRowContext(ctx, loginAttemptStatusQuery, email, ip, now.Add(-LoginAttemptWindow)))
+	if err != nil {
+		return 0, err
+	}
+	if retryAfter := status.RetryAfter(now); retryAfter > 0 {
+		return retryAfter, nil
+	}
+	query = `
+	INSERT INTO login_attempts (email, ip)
+	VALUES ($1, $2)`
+	_, err = tx.ExecContext(ctx, query, email, ip)
+	if err != nil {
+		return 0, err
+	}
+	return 0, tx.Commit()
+}
+
+const loginAttemptStatusQuery = `
+	SELECT
+		count(*) FILTER (WHERE email = $1),
+		max(created_at) FILTER (WHERE email = $1),
+		count(*) FILTER (WHERE ip = $2),
+		max(created_at) FILTER (WHERE ip = $2)
+	FROM login_attempts
+	WHERE (email = $1 OR ip = $2) AND created_at > $3`
+
+// The GetStatus() method counts the recent failed login attempts for an email address
+// and for an IP address.
+func (m LoginAttemptModel) GetStatus(email, ip string) (LoginAttemptStatus, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return scanLoginAttemptStatus(m.DB.QueryRowContext(ctx, loginAttemptStatusQuery, email, ip, time.Now().Add(-LoginAttemptWindow)))
+}
+
+func scanLoginAttemptStatus(row *sql.Row) (LoginAttemptStatus, error) {
+	var status LoginAttemptStatus
+	var accountLastFailure, ipLastFailure sql.NullTime
+	err := row.Scan(
+		&status.AccountFailures,
+		&accountLastFailure,
+		&status.IPFailures,
+		&ipLastFailure,
+	)
+	if err != nil {
+		return LoginAttemptStatus{}, err
+	}
+	status.AccountLastFailure = accountLastFailure.Time
+	status.IPLastFailure = ipLastFailure.Time
+	return status, nil
+}
+
+// The DeleteAllForEmail() method forgets the failed attempts for an email address. We
+// call this after a successful login. Failures recorded against the IP address are
+// kept, so that one valid account can't be used to reset the IP address limit.
+func (m LoginAttemptModel) DeleteAllForEmail(email string) error {
+	query := `
+	DELETE FROM login_attempts
+	WHERE email = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, email)
+	return err
+}
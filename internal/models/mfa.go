@@ -0,0 +1,260 @@
+package models
+
+import (
+	"cinemaGo/pkg/totp"
+	"cinemaGo/pkg/validator"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrTOTPCodeReused = errors.New("totp code already used")
+)
+
+// The number of recovery codes issued when two-factor authentication is enabled.
+const RecoveryCodeCount = 10
+
+// A TOTP holds a user's two-factor authentication secret. The secret isn't active until
+// the user has proved that their authenticator app is set up correctly by confirming
+// a code, at which point ConfirmedAt is set.
+type TOTP struct {
+	UserID       int64
+	CreatedAt    time.Time
+	Secret       string
+	ConfirmedAt  *time.Time
+	LastUsedStep int64
+}
+
+func (t *TOTP) Enabled() bool {
+	return t.ConfirmedAt != nil
+}
+
+// The Validate() method checks a code against the secret, returning the time step that
+// the code belongs to if it is valid.
+func (t *TOTP) Validate(code string, now time.Time) (int64, bool) {
+	return totp.Validate(t.Secret, code, now)
+}
+
+// Check that a TOTP code has been provided and looks like a six digit number.
+func ValidateTOTPCode(v *validator.Validator, code string) {
+	v.Check(code != "", "code", "must be provided")
+	v.Check(len(code) == 6 && strings.Trim(code, "0123456789") == "", "code", "must be a 6 digit number")
+}
+
+// Recovery codes are shown to the user in the form XXXXX-XXXXX, but we accept them with
+// or without the hyphen and in any case.
+func normalizeRecoveryCode(code string) string {
+	return strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
+
+func ValidateRecoveryCode(v *validator.Validator, code string) {
+	v.Check(code != "", "recovery_code", "must be provided")
+	v.Check(len(normalizeRecoveryCode(code)) == 10, "recovery_code", "must be 10 characters long")
+}
+
+func generateRecoveryCode() (string, error) {
+	randomBytes := make([]byte, 10)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+	code := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+func hashRecoveryCode(code string) []byte {
+	hash := sha256.Sum256([]byte(normalizeRecoveryCode(code)))
+	return hash[:]
+}
+
+// Define the MFAModel type.
+type MFAModel struct {
+	DB *sql.DB
+}
+
+// The GetTOTP() method returns the TOTP secret for a user, or ErrRecordNotFound if they
+// haven't started enrolling.
+func (m MFAModel) GetTOTP(userID int64) (*TOTP, error) {
+	query := `
+	SELECT user_id, created_at, secret, confirmed_at, last_used_step
+	FROM totp_secrets
+	WHERE user_id = $1`
+	var record TOTP
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(
+		&record.UserID,
+		&record.CreatedAt,
+		&record.Secret,
+		&record.ConfirmedAt,
+		&record.LastUsedStep,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &record, nil
+}
+
+// The SetTOTP() method stores a new, unconfirmed secret for a user, replacing any
+// earlier unconfirmed one. If the user already has a confirmed secret it is left
+// alone and ErrEditConflict is returned.
+func (m MFAModel) SetTOTP(userID int64, secret string) (*TOTP, error) {
+	query := `
+	INSERT INTO totp_secrets (user_id, secret)
+	VALUES ($1, $2)
+	ON CONFLICT (user_id) DO UPDATE
+	SET secret = EXCLUDED.secret, created_at = NOW(), last_used_step = 0
+	WHERE totp_secrets.confirmed_at IS NULL
+	RETURNING created_at`
+	record := &TOTP{UserID: userID, Secret: secret}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, userID, secret).Scan(&record.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrEditConflict
+		default:
+			return nil, err
+		}
+	}
+	return record, nil
+}
+
+// The ConfirmTOTP() method activates a user's secret, recording the time step of the
+// code that was used to confirm it.
+func (m MFAModel) ConfirmTOTP(userID int64, step int64) error {
+	query := `
+	UPDATE totp_secrets
+	SET confirmed_at = NOW(), last_used_step = $2
+	WHERE user_id = $1 AND confirmed_at IS NULL`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+	return nil
+}
+
+// The UseTOTPStep() method records that a code for the given time step has been used.
+// Each code can only be used once, so if the step isn't later than the last one used
+// we return ErrTOTPCodeReused.
+func (m MFAModel) UseTOTPStep(userID int64, step int64) error {
+	query := `
+	UPDATE totp_secrets
+	SET last_used_step = $2
+	WHERE user_id = $1 AND last_used_step < $2`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrTOTPCodeReused
+	}
+	return nil
+}
+
+// The DeleteAllForUser() method turns off two-factor authentication for a user by
+// removing their secret and any remaining recovery codes.
+func (m MFAModel) DeleteAllForUser(userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM totp_secrets WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// The NewRecoveryCodes() method generates a fresh set of recovery codes for a user,
+// replacing any old ones. Only the hashes are stored, so the plaintext codes returned
+// here must be shown to the user straight away.
+func (m MFAModel) NewRecoveryCodes(userID int64) ([]string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, code := range codes {
+		_, err = tx.ExecContext(ctx, `INSERT INTO recovery_codes (hash, user_id) VALUES ($1, $2)`, hashRecoveryCode(code), userID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// The UseRecoveryCode() method checks a recovery code and, if it is valid, deletes it
+// so that it can't be used again. If the code doesn't match we return
+// ErrRecordNotFound.
+func (m MFAModel) UseRecoveryCode(userID int64, code string) error {
+	query := `
+	DELETE FROM recovery_codes
+	WHERE user_id = $1 AND hash = $2`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, userID, hashRecoveryCode(code))
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
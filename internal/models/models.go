@@ -1,8 +1,12 @@
 package models
 
 import (
+	"cinemaGo/pkg/jwt"
+	"cinemaGo/pkg/oidc"
+	"cinemaGo/pkg/wordfilter"
 	"database/sql"
 	"errors"
+	"time"
 )
 
 // Define a custom ErrRecordNotFound error. We'll return this from our Get() method when
@@ -13,17 +17,57 @@ var (
 )
 
 type Models struct {
-	Movies      MovieModel
-	Permissions PermissionModel // Add a new Permissions field.
-	Tokens      TokenModel
-	Users       UserModel
+	APIKeys       APIKeyModel
+	Cinemas       CinemaModel
+	Comments      CommentModel
+	Credits       CreditModel
+	LoginAttempts LoginAttemptModel
+	JWT           JWTModel
+	MFA           MFAModel
+	Movies        MovieModel
+	OAuth         OAuthModel
+	OIDC          OIDCModel
+	People        PersonModel
+	Permissions   PermissionModel // Add a new Permissions field.
+	Reviews       ReviewModel
+	Roles         RoleModel
+	Tokens        TokenModel
+	Users         UserModel
+	Watched       MovieListModel
+	Watchlist     MovieListModel
 }
 
-func NewModels(db *sql.DB) Models {
+// The permissionsCacheTTL parameter controls how long a user's effective permissions are
+// cached in memory for. A value of zero disables the cache. The jwtKeys parameter is
+// only needed when JWT authentication tokens are enabled, and the oidcProvider only
+// when sign-in with an external identity provider is; either may be nil otherwise. The
+// commentFilter is used to flag comments for moderation, and may also be nil.
+func NewModels(db *sql.DB, permissionsCacheTTL time.Duration, jwtKeys *jwt.KeySet, oidcProvider *oidc.Provider, commentFilter wordfilter.Filter) Models {
+	permissionCache := NewPermissionCache(permissionsCacheTTL)
+	revoked := newRevocationList()
+	// The tokens model only needs the revocation list in JWT mode.
+	tokens := TokenModel{DB: db}
+	if jwtKeys != nil {
+		tokens.revoked = revoked
+	}
 	return Models{
-		Movies:      MovieModel{DB: db},
-		Permissions: PermissionModel{DB: db}, // Initialize a new PermissionModel instance.
-		Tokens:      TokenModel{DB: db},
-		Users:       UserModel{DB: db},
+		APIKeys:       APIKeyModel{DB: db},
+		Cinemas:       CinemaModel{DB: db},
+		Comments:      CommentModel{DB: db, Filter: commentFilter},
+		Credits:       CreditModel{DB: db},
+		LoginAttempts: LoginAttemptModel{DB: db},
+		JWT:           JWTModel{DB: db, Keys: jwtKeys, revoked: revoked},
+		MFA:           MFAModel{DB: db},
+		Movies:        MovieModel{DB: db},
+		OAuth:         OAuthModel{DB: db},
+		OIDC:          OIDCModel{DB: db, Provider: oidcProvider},
+		People:        PersonModel{DB: db},
+		Permissions:   PermissionModel{DB: db, Cache: permissionCache}, // Initialize a new PermissionModel instance.
+		Reviews:       ReviewModel{DB: db},
+		Roles:         RoleModel{DB: db, Cache: permissionCache},
+		Tokens:        tokens,
+		Users:         UserModel{DB: db},
+		Watched:       MovieListModel{DB: db, table: "watched_movies"},
+		Watchlist:     MovieListModel{DB: db, table: "watchlist"},
 	}
 }
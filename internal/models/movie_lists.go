@@ -0,0 +1,151 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// A ListedMovie is a movie on one of a user's lists, along with when it was added.
+type ListedMovie struct {
+	*Movie
+	AddedAt time.Time `json:"added_at"`
+}
+
+// Define the MovieListModel type. Each user has two lists of movies, their watchlist of
+// movies to watch later and the log of movies they have watched, which work in exactly
+// the same way but are stored in separate tables. The table is set by NewModels() and
+// never comes from user input.
+type MovieListModel struct {
+	DB    *sql.DB
+	table string
+}
+
+// The Add() method adds a movie to the user's list and returns when it was added.
+// Adding a movie which is already on the list leaves it where it is.
+func (m MovieListModel) Add(userID, movieID int64) (time.Time, error) {
+	query := fmt.Sprintf(`
+	INSERT INTO %[1]s (user_id, movie_id)
+	VALUES ($1, $2)
+	ON CONFLICT (user_id, movie_id) DO UPDATE SET added_at = %[1]s.added_at
+	RETURNING added_at`, m.table)
+	var addedAt time.Time
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, userID, movieID).Scan(&addedAt)
+	return addedAt, err
+}
+
+// The Remove() method takes a movie off the user's list. If it wasn't on the list we
+// return ErrRecordNotFound.
+func (m MovieListModel) Remove(userID, movieID int64) error {
+	query := fmt.Sprintf(`
+	DELETE FROM %s
+	WHERE user_id = $1 AND movie_id = $2`, m.table)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, userID, movieID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// The GetAll() method returns a page of the movies on the user's list.
+func (m MovieListModel) GetAll(userID int64, filters Filters) ([]*ListedMovie, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version,
+		COALESCE(average_rating, 0), COALESCE(review_count, 0), list.added_at
+	FROM %s AS list
+	INNER JOIN movies ON movies.id = list.movie_id`+ratingsJoin+`
+	WHERE list.user_id = $1
+	ORDER BY %s %s, id ASC
+	LIMIT $2 OFFSET $3`, m.table, filters.sortColumn(), filters.sortDirection())
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	movies := []*ListedMovie{}
+	for rows.Next() {
+		movie := ListedMovie{Movie: &Movie{}}
+		err := rows.Scan(
+			&totalRecords,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.AverageRating,
+			&movie.ReviewCount,
+			&movie.AddedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		movies = append(movies, &movie)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return movies, metadata, nil
+}
+
+// The GetAllForUser() method returns every movie on the user's list, most recently
+// added first. It is used when exporting the user's data.
+func (m MovieListModel) GetAllForUser(userID int64) ([]*ListedMovie, error) {
+	query := fmt.Sprintf(`
+	SELECT id, created_at, title, year, runtime, genres, version,
+		COALESCE(average_rating, 0), COALESCE(review_count, 0), list.added_at
+	FROM %s AS list
+	INNER JOIN movies ON movies.id = list.movie_id`+ratingsJoin+`
+	WHERE list.user_id = $1
+	ORDER BY list.added_at DESC, id ASC`, m.table)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	movies := []*ListedMovie{}
+	for rows.Next() {
+		movie := ListedMovie{Movie: &Movie{}}
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.AverageRating,
+			&movie.ReviewCount,
+			&movie.AddedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		movies = append(movies, &movie)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return movies, nil
+}
@@ -22,9 +22,25 @@ type Movie struct {
 	// won't be called at all.
 	Runtime Runtime  `json:"runtime,omitempty"`
 	Genres  []string `json:"genres,omitempty"`
-	Version int32    `json:"version"`
+	// AverageRating and ReviewCount are calculated from the movie's reviews. The
+	// average is rounded to one decimal place, and is zero if there are no reviews.
+	AverageRating float64 `json:"average_rating,omitempty"`
+	ReviewCount   int64   `json:"review_count"`
+	// Credits is only filled in when the client asks for it.
+	Credits []*Credit `json:"credits,omitempty"`
+	Version int32     `json:"version"`
 }
 
+// The ratingsJoin fragment joins the aggregated ratings for each movie from the reviews
+// table. Movies without any reviews have NULL ratings, so they need to be wrapped in
+// COALESCE() when selected.
+const ratingsJoin = `
+LEFT JOIN (
+	SELECT movie_id, round(avg(rating), 1) AS average_rating, count(*) AS review_count
+	FROM reviews
+	GROUP BY movie_id
+) AS ratings ON ratings.movie_id = movies.id`
+
 func ValidateMovie(v *validator.Validator, movie *Movie) {
 	v.Check(movie.Title != "", "title", "must be provided")
 	v.Check(len(movie.Title) <= 500, "title", "must not be more than 500 bytes long")
@@ -63,8 +79,9 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	}
 	// Remove the pg_sleep(10) clause.
 	query := `
-	SELECT id, created_at, title, year, runtime, genres, version
-	FROM movies
+	SELECT id, created_at, title, year, runtime, genres, version,
+		COALESCE(average_rating, 0), COALESCE(review_count, 0)
+	FROM movies` + ratingsJoin + `
 	WHERE id = $1`
 	var movie Movie
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -78,6 +95,8 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 		&movie.Runtime,
 		pq.Array(&movie.Genres),
 		&movie.Version,
+		&movie.AverageRating,
+		&movie.ReviewCount,
 	)
 	if err != nil {
 		switch {
@@ -150,10 +169,12 @@ func (m MovieModel) Delete(id int64) error {
 // Update the function signature to return a Metadata struct.
 func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
 	// Update the SQL query to include the LIMIT and OFFSET clauses with placeholder
-	// parameter values.
+	// parameter values. The ratings are selected under the same names as the sort
+	// values, so that they can be sorted on directly.
 	query := fmt.Sprintf(`
-SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version
-FROM movies
+SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version,
+	COALESCE(average_rating, 0) AS average_rating, COALESCE(review_count, 0) AS review_count
+FROM movies`+ratingsJoin+`
 WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
 AND (genres @> $2 OR $2 = '{}')
 ORDER BY %s %s, id ASC
@@ -180,6 +201,8 @@ LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
 			&movie.Runtime,
 			pq.Array(&movie.Genres),
 			&movie.Version,
+			&movie.AverageRating,
+			&movie.ReviewCount,
 		)
 		if err != nil {
 			return nil, Metadata{}, err // Update this to return an empty Metadata struct.
@@ -0,0 +1,366 @@
+package models
+
+import (
+	"cinemaGo/pkg/validator"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// The lifetime of an authorization code. The client should exchange it for tokens
+// straight away, so this can be short.
+const OAuthCodeTTL = 10 * time.Minute
+
+// PKCE code verifiers are 43-128 characters from the unreserved set (RFC 7636 section
+// 4.1). The S256 code challenge is always 43 base64url characters.
+var (
+	CodeVerifierRX  = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+	CodeChallengeRX = regexp.MustCompile(`^[A-Za-z0-9\-_]{43}$`)
+)
+
+// An OAuthClient is a third-party application which can ask users for access to their
+// account. Confidential clients (for example, a partner's web server) are issued a
+// secret; public clients (for example, a mobile app) can't keep a secret, and rely on
+// PKCE alone. The Secret is only available at the moment the client is registered.
+type OAuthClient struct {
+	ID           string    `json:"id"`
+	Secret       string    `json:"secret,omitempty"`
+	SecretHash   []byte    `json:"-"`
+	UserID       int64     `json:"-"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Confidential bool      `json:"confidential"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// The AllowsRedirectURI() method reports whether a redirect URI exactly matches one of
+// those registered for the client.
+func (c *OAuthClient) AllowsRedirectURI(uri string) bool {
+	for _, registered := range c.RedirectURIs {
+		if uri == registered {
+			return true
+		}
+	}
+	return false
+}
+
+// The MatchesSecret() method checks a client secret in constant time.
+func (c *OAuthClient) MatchesSecret(secret string) bool {
+	hash := sha256.Sum256([]byte(secret))
+	return subtle.ConstantTimeCompare(hash[:], c.SecretHash) == 1
+}
+
+// Redirect URIs must be absolute URLs without a fragment. Plain http is only allowed
+// for localhost, for use during development.
+func validRedirectURI(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil || u.Fragment != "" || u.Host == "" {
+		return false
+	}
+	switch u.Scheme {
+	case "https":
+		return true
+	case "http":
+		return u.Hostname() == "localhost" || u.Hostname() == "127.0.0.1"
+	default:
+		return false
+	}
+}
+
+func ValidateOAuthClient(v *validator.Validator, client *OAuthClient) {
+	v.Check(client.Name != "", "name", "must be provided")
+	v.Check(len(client.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(client.RedirectURIs != nil, "redirect_uris", "must be provided")
+	v.Check(len(client.RedirectURIs) >= 1, "redirect_uris", "must contain at least 1 URI")
+	v.Check(len(client.RedirectURIs) <= 10, "redirect_uris", "must not contain more than 10 URIs")
+	v.Check(validator.Unique(client.RedirectURIs), "redirect_uris", "must not contain duplicate values")
+	for _, uri := range client.RedirectURIs {
+		if !validRedirectURI(uri) {
+			v.AddError("redirect_uris", "must all be absolute https URLs without a fragment")
+			break
+		}
+	}
+}
+
+// An OAuthCode is an authorization code, issued when a user approves a client's request.
+// It records everything needed to check the client's token request: the redirect URI
+// and PKCE code challenge from the authorization request, and the permission codes
+// that the user granted.
+type OAuthCode struct {
+	Plaintext     string
+	ClientID      string
+	UserID        int64
+	RedirectURI   string
+	Permissions   Permissions
+	CodeChallenge string
+	Expiry        time.Time
+}
+
+// An OAuthGrant is a client's access to a user's account, which the user approved. It
+// lasts as long as the session that the client was issued, and carries the permission
+// codes that the user granted.
+type OAuthGrant struct {
+	SessionID   int64       `json:"session_id"`
+	ClientID    string      `json:"client_id"`
+	ClientName  string      `json:"client_name"`
+	Permissions Permissions `json:"permissions"`
+	CreatedAt   time.Time   `json:"created_at"`
+	Expiry      time.Time   `json:"expiry"`
+}
+
+// The VerifyCodeVerifier() method checks a PKCE code verifier against the code
+// challenge, using the S256 method: the challenge must be the base64url encoded SHA-256
+// hash of the verifier.
+func (c *OAuthCode) VerifyCodeVerifier(verifier string) bool {
+	hash := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(hash[:])
+	return subtle.ConstantTimeCompare([]byte(challenge), []byte(c.CodeChallenge)) == 1
+}
+
+func randomString(n int) (string, error) {
+	randomBytes := make([]byte, n)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes), nil
+}
+
+// Define the OAuthModel type.
+type OAuthModel struct {
+	DB *sql.DB
+}
+
+// The InsertClient() method registers a new client, generating its ID and, for
+// confidential clients, its secret.
+func (m OAuthModel) InsertClient(client *OAuthClient) error {
+	id, err := randomString(10)
+	if err != nil {
+		return err
+	}
+	client.ID = id
+	if client.Confidential {
+		client.Secret, err = randomString(20)
+		if err != nil {
+			return err
+		}
+		hash := sha256.Sum256([]byte(client.Secret))
+		client.SecretHash = hash[:]
+	}
+	query := `
+	INSERT INTO oauth_clients (id, secret_hash, user_id, name, redirect_uris)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING created_at`
+	args := []interface{}{client.ID, client.SecretHash, client.UserID, client.Name, pq.Array(client.RedirectURIs)}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&client.CreatedAt)
+}
+
+// The GetClient() method returns a single client by ID.
+func (m OAuthModel) GetClient(id string) (*OAuthClient, error) {
+	query := `
+	SELECT id, secret_hash, user_id, name, redirect_uris, created_at
+	FROM oauth_clients
+	WHERE id = $1`
+	var client OAuthClient
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&client.ID,
+		&client.SecretHash,
+		&client.UserID,
+		&client.Name,
+		pq.Array(&client.RedirectURIs),
+		&client.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	client.Confidential = client.SecretHash != nil
+	return &client, nil
+}
+
+// The GetAllClientsForUser() method returns the clients registered by a user, most
+// recently created first.
+func (m OAuthModel) GetAllClientsForUser(userID int64) ([]*OAuthClient, error) {
+	query := `
+	SELECT id, secret_hash, user_id, name, redirect_uris, created_at
+	FROM oauth_clients
+	WHERE user_id = $1
+	ORDER BY created_at DESC, id`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	clients := []*OAuthClient{}
+	for rows.Next() {
+		var client OAuthClient
+		err := rows.Scan(
+			&client.ID,
+			&client.SecretHash,
+			&client.UserID,
+			&client.Name,
+			pq.Array(&client.RedirectURIs),
+			&client.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		client.Confidential = client.SecretHash != nil
+		clients = append(clients, &client)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
+
+// The GetAllGrantsForUser() method returns the clients that a user has given access to
+// their account and which still have an active session, most recently approved first.
+// It is used when exporting the user's data.
+func (m OAuthModel) GetAllGrantsForUser(userID int64) ([]*OAuthGrant, error) {
+	query := `
+	SELECT COALESCE(tokens.session_id, tokens.id) AS session_id, oauth_clients.id, oauth_clients.name, tokens.permissions,
+		min(tokens.created_at) AS created_at, max(tokens.expiry) FILTER (WHERE tokens.used_at IS NULL) AS expiry
+	FROM tokens
+	INNER JOIN oauth_clients ON oauth_clients.id = tokens.client_id
+	WHERE tokens.user_id = $1 AND tokens.scope = ANY($2)
+	GROUP BY COALESCE(tokens.session_id, tokens.id), oauth_clients.id, tokens.permissions
+	HAVING max(tokens.expiry) FILTER (WHERE tokens.used_at IS NULL) > $3
+	ORDER BY created_at DESC, session_id DESC`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID, pq.Array([]string{ScopeAuthentication, ScopeRefresh}), time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	grants := []*OAuthGrant{}
+	for rows.Next() {
+		var grant OAuthGrant
+		err := rows.Scan(
+			&grant.SessionID,
+			&grant.ClientID,
+			&grant.ClientName,
+			pq.Array(&grant.Permissions),
+			&grant.CreatedAt,
+			&grant.Expiry,
+		)
+		if err != nil {
+			return nil, err
+		}
+		grants = append(grants, &grant)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return grants, nil
+}
+
+// The DeleteClient() method removes a client registered by the given user. Outstanding
+// authorization codes and tokens issued to the client are removed by ON DELETE
+// CASCADE.
+func (m OAuthModel) DeleteClient(userID int64, id string) error {
+	query := `
+	DELETE FROM oauth_clients
+	WHERE id = $1 AND user_id = $2`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// The NewCode() method generates and stores an authorization code. As with tokens, only
+// a hash of the code is stored. Codes which were never exchanged are only deleted here,
+// once they have expired, so that they don't pile up.
+func (m OAuthModel) NewCode(code *OAuthCode) error {
+	plaintext, err := randomString(20)
+	if err != nil {
+		return err
+	}
+	code.Plaintext = plaintext
+	code.Expiry = time.Now().Add(OAuthCodeTTL)
+	hash := sha256.Sum256([]byte(code.Plaintext))
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `DELETE FROM oauth_codes WHERE expiry <= $1`, time.Now())
+	if err != nil {
+		return err
+	}
+	query := `
+	INSERT INTO oauth_codes (hash, client_id, user_id, redirect_uri, permissions, code_challenge, expiry)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	args := []interface{}{hash[:], code.ClientID, code.UserID, code.RedirectURI, pq.Array([]string(code.Permissions)), code.CodeChallenge, code.Expiry}
+	_, err = tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// The ConsumeCode() method looks up an unexpired authorization code and deletes it in
+// the same statement, so that each code can only ever be exchanged once.
+func (m OAuthModel) ConsumeCode(plaintext string) (*OAuthCode, error) {
+	hash := sha256.Sum256([]byte(plaintext))
+	query := `
+	DELETE FROM oauth_codes
+	WHERE hash = $1
+	RETURNING client_id, user_id, redirect_uri, permissions, code_challenge, expiry`
+	code := OAuthCode{Plaintext: plaintext}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, hash[:]).Scan(
+		&code.ClientID,
+		&code.UserID,
+		&code.RedirectURI,
+		pq.Array(&code.Permissions),
+		&code.CodeChallenge,
+		&code.Expiry,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	if !code.Expiry.After(time.Now()) {
+		return nil, ErrRecordNotFound
+	}
+	return &code, nil
+}
@@ -0,0 +1,185 @@
+package models
+
+import (
+	"cinemaGo/pkg/oidc"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"time"
+)
+
+// How long the user has to sign in at the identity provider and come back.
+const OIDCLoginTTL = 10 * time.Minute
+
+// An OIDCLogin records a sign-in which has been started at the identity provider. The
+// State is sent to the provider and echoed back in the redirect, so that we can find
+// the login again; the Nonce must come back in the ID token; and the CodeVerifier is
+// the PKCE secret needed to redeem the authorization code.
+type OIDCLogin struct {
+	State        string
+	Nonce        string
+	CodeVerifier string
+	Expiry       time.Time
+}
+
+// The CodeChallenge() method returns the PKCE S256 challenge for the login's code
+// verifier.
+func (l *OIDCLogin) CodeChallenge() string {
+	hash := sha256.Sum256([]byte(l.CodeVerifier))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// An Identity is a user's account at an identity provider, which they have signed in
+// with.
+type Identity struct {
+	Issuer    string    `json:"issuer"`
+	Subject   string    `json:"subject"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Define the OIDCModel type. The Provider is nil if sign-in with an external identity
+// provider isn't configured.
+type OIDCModel struct {
+	DB       *sql.DB
+	Provider *oidc.Provider
+}
+
+// The NewLogin() method generates and stores the secrets for a new sign-in. Only a hash
+// of the state is stored, but the nonce and code verifier must be kept in plaintext as
+// we need to send them on later. Logins which were never completed are only deleted
+// here, once they have expired, so that they don't pile up.
+func (m OIDCModel) NewLogin() (*OIDCLogin, error) {
+	var login OIDCLogin
+	var err error
+	login.State, err = randomString(20)
+	if err != nil {
+		return nil, err
+	}
+	login.Nonce, err = randomString(20)
+	if err != nil {
+		return nil, err
+	}
+	login.CodeVerifier, err = randomString(32)
+	if err != nil {
+		return nil, err
+	}
+	login.Expiry = time.Now().Add(OIDCLoginTTL)
+	hash := sha256.Sum256([]byte(login.State))
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `DELETE FROM oidc_logins WHERE expiry <= $1`, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	query := `
+	INSERT INTO oidc_logins (state_hash, nonce, code_verifier, expiry)
+	VALUES ($1, $2, $3, $4)`
+	_, err = tx.ExecContext(ctx, query, hash[:], login.Nonce, login.CodeVerifier, login.Expiry)
+	if err != nil {
+		return nil, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return &login, nil
+}
+
+// The ConsumeLogin() method looks up an unexpired login by its state and deletes it in
+// the same statement, so that each one can only be completed once.
+func (m OIDCModel) ConsumeLogin(state string) (*OIDCLogin, error) {
+	hash := sha256.Sum256([]byte(state))
+	query := `
+	DELETE FROM oidc_logins
+	WHERE state_hash = $1
+	RETURNING nonce, code_verifier, expiry`
+	login := OIDCLogin{State: state}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, hash[:]).Scan(&login.Nonce, &login.CodeVerifier, &login.Expiry)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	if !login.Expiry.After(time.Now()) {
+		return nil, ErrRecordNotFound
+	}
+	return &login, nil
+}
+
+// The GetUserIDForIdentity() method returns the ID of the user linked to an identity
+// at a provider. Identities are keyed on the issuer and subject, which the provider
+// guarantees never to reuse, rather than on the email address, which can change.
+func (m OIDCModel) GetUserIDForIdentity(issuer, subject string) (int64, error) {
+	query := `
+	SELECT user_id
+	FROM user_identities
+	WHERE issuer = $1 AND subject = $2`
+	var userID int64
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, issuer, subject).Scan(&userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+	return userID, nil
+}
+
+// The LinkIdentity() method links an identity at a provider to a user, so that they
+// are recognised next time even if their email address has changed.
+func (m OIDCModel) LinkIdentity(userID int64, issuer, subject string) error {
+	query := `
+	INSERT INTO user_identities (issuer, subject, user_id)
+	VALUES ($1, $2, $3)
+	ON CONFLICT DO NOTHING`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, issuer, subject, userID)
+	return err
+}
+
+// The GetAllIdentitiesForUser() method returns the identities linked to a user, most
+// recently linked first. It is used when exporting the user's data.
+func (m OIDCModel) GetAllIdentitiesForUser(userID int64) ([]*Identity, error) {
+	query := `
+	SELECT issuer, subject, created_at
+	FROM user_identities
+	WHERE user_id = $1
+	ORDER BY created_at DESC, issuer, subject`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	identities := []*Identity{}
+	for rows.Next() {
+		var identity Identity
+		err := rows.Scan(&identity.Issuer, &identity.Subject, &identity.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, &identity)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return identities, nil
+}
@@ -1,13 +1,23 @@
 package models
 
 import (
+	"cinemaGo/pkg/validator"
 	"context"
 	"database/sql"
+	"errors"
+	"regexp"
 	"time"
 
 	"github.com/lib/pq"
 )
 
+var (
+	ErrDuplicatePermission = errors.New("duplicate permission")
+)
+
+// Permission codes take the form "resource:action", for example "movies:read".
+var PermissionCodeRX = regexp.MustCompile("^[a-z][a-z0-9_-]*:[a-z][a-z0-9_-]*$")
+
 type Permissions []string
 
 // Add a helper method to check whether the Permissions slice contains a specific
@@ -21,9 +31,29 @@ func (p Permissions) Include(code string) bool {
 	return false
 }
 
-// Define the PermissionModel type.
+func ValidatePermissionCode(v *validator.Validator, code string) {
+	v.Check(code != "", "code", "must be provided")
+	v.Check(len(code) <= 100, "code", "must not be more than 100 bytes long")
+	v.Check(validator.Matches(code, PermissionCodeRX), "code", "must be in the format resource:action")
+}
+
+func ValidatePermissionCodes(v *validator.Validator, codes []string) {
+	v.Check(codes != nil, "codes", "must be provided")
+	v.Check(len(codes) >= 1, "codes", "must contain at least 1 code")
+	v.Check(validator.Unique(codes), "codes", "must not contain duplicate values")
+	for _, code := range codes {
+		if !validator.Matches(code, PermissionCodeRX) {
+			v.AddError("codes", "must all be in the format resource:action")
+			break
+		}
+	}
+}
+
+// Define the PermissionModel type. The Cache is shared with the RoleModel, so that
+// changes to either kind of grant invalidate the same cached entries.
 type PermissionModel struct {
-	DB *sql.DB
+	DB    *sql.DB
+	Cache *PermissionCache
 }
 
 // The GetAllForUser() method returns all permission codes for a specific user in a
@@ -59,15 +89,131 @@ WHERE users.id = $1`
 	return permissions, nil
 }
 
+// The GetEffectiveForUser() method returns the full set of permission codes that apply
+// to a user: those granted to them directly, plus those that come from any roles they
+// hold. This is the set that should be used for access control decisions, so the
+// result is served from the permission cache when possible.
+func (m PermissionModel) GetEffectiveForUser(userID int64) (Permissions, error) {
+	permissions, generation, found := m.Cache.get(userID)
+	if found {
+		return permissions, nil
+	}
+	query := `
+	SELECT permissions.code
+	FROM permissions
+	INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
+	WHERE users_permissions.user_id = $1
+	UNION
+	SELECT permissions.code
+	FROM permissions
+	INNER JOIN roles_permissions ON roles_permissions.permission_id = permissions.id
+	INNER JOIN users_roles ON users_roles.role_id = roles_permissions.role_id
+	WHERE users_roles.user_id = $1
+	ORDER BY code`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	permissions = Permissions{}
+	for rows.Next() {
+		var permission string
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	m.Cache.set(userID, permissions, generation)
+	return permissions, nil
+}
+
 // Add the provided permission codes for a specific user. Notice that we're using a
 // variadic parameter for the codes so that we can assign multiple permissions in a
 // single call.
 func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
 	query := `
 	INSERT INTO users_permissions
-	SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+	SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+	ON CONFLICT DO NOTHING`
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
-	return err
+	if err != nil {
+		return err
+	}
+	m.Cache.Invalidate(userID)
+	return nil
+}
+
+// The RemoveForUser() method revokes the provided permission codes from a specific user.
+// Codes that the user doesn't hold are ignored.
+func (m PermissionModel) RemoveForUser(userID int64, codes ...string) error {
+	query := `
+	DELETE FROM users_permissions
+	USING permissions
+	WHERE users_permissions.permission_id = permissions.id
+	AND users_permissions.user_id = $1
+	AND permissions.code = ANY($2)`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	if err != nil {
+		return err
+	}
+	m.Cache.Invalidate(userID)
+	return nil
+}
+
+// The GetAll() method returns every permission code that exists, in alphabetical order.
+func (m PermissionModel) GetAll() (Permissions, error) {
+	query := `
+	SELECT code
+	FROM permissions
+	ORDER BY code`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	permissions := Permissions{}
+	for rows.Next() {
+		var permission string
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return permissions, nil
+}
+
+// The Insert() method creates a new permission code. If the code already exists we
+// return an ErrDuplicatePermission error.
+func (m PermissionModel) Insert(code string) error {
+	query := `
+	INSERT INTO permissions (code)
+	VALUES ($1)`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, code)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "permissions_code_key"`:
+			return ErrDuplicatePermission
+		default:
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,122 @@
+package models
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The PermissionCache type holds the effective permission codes for recently seen
+// users in memory, so that requirePermission() doesn't need to query the database on
+// every request. Entries expire after the configured TTL, and are invalidated
+// explicitly by the PermissionModel and RoleModel methods which change grants.
+//
+// A nil *PermissionCache, or one with a TTL of zero, is valid and caches nothing.
+type PermissionCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[int64]permissionCacheEntry
+	// The generation is bumped on every invalidation. A lookup records the generation
+	// before it goes to the database, and the result is only stored if nothing was
+	// invalidated in the meantime. This stops a slow query from putting stale
+	// permissions back into the cache straight after a grant has changed.
+	generation uint64
+	hits       atomic.Int64
+	misses     atomic.Int64
+}
+
+type permissionCacheEntry struct {
+	permissions Permissions
+	expiry      time.Time
+}
+
+// PermissionCacheStats holds the counters that we expose for monitoring.
+type PermissionCacheStats struct {
+	Hits    int64 `json:"hits"`
+	Misses  int64 `json:"misses"`
+	Entries int   `json:"entries"`
+}
+
+func NewPermissionCache(ttl time.Duration) *PermissionCache {
+	return &PermissionCache{
+		ttl:     ttl,
+		entries: make(map[int64]permissionCacheEntry),
+	}
+}
+
+func (c *PermissionCache) enabled() bool {
+	return c != nil && c.ttl > 0
+}
+
+// The get() method returns the cached permissions for a user, if there are any which
+// haven't expired. On a miss it also returns the current generation, which should be
+// passed to set() along with the freshly loaded permissions.
+func (c *PermissionCache) get(userID int64) (Permissions, uint64, bool) {
+	if !c.enabled() {
+		return nil, 0, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, found := c.entries[userID]
+	if found && time.Now().Before(entry.expiry) {
+		c.hits.Add(1)
+		return entry.permissions, c.generation, true
+	}
+	if found {
+		delete(c.entries, userID)
+	}
+	c.misses.Add(1)
+	return nil, c.generation, false
+}
+
+func (c *PermissionCache) set(userID int64, permissions Permissions, generation uint64) {
+	if !c.enabled() {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation {
+		return
+	}
+	c.entries[userID] = permissionCacheEntry{
+		permissions: permissions,
+		expiry:      time.Now().Add(c.ttl),
+	}
+}
+
+// The Invalidate() method drops the cached permissions for a single user.
+func (c *PermissionCache) Invalidate(userID int64) {
+	if !c.enabled() {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	delete(c.entries, userID)
+}
+
+// The InvalidateAll() method empties the cache. We use this when a change could affect
+// many users at once, such as a role's permissions being edited.
+func (c *PermissionCache) InvalidateAll() {
+	if !c.enabled() {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.entries = make(map[int64]permissionCacheEntry)
+}
+
+func (c *PermissionCache) Stats() PermissionCacheStats {
+	if c == nil {
+		return PermissionCacheStats{}
+	}
+	c.mu.Lock()
+	entries := len(c.entries)
+	c.mu.Unlock()
+	return PermissionCacheStats{
+		Hits:    c.hits.Load(),
+		Misses:  c.misses.Load(),
+		Entries: entries,
+	}
+}
@@ -0,0 +1,275 @@
+package models
+
+import (
+	"cinemaGo/pkg/validator"
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrDuplicateRole = errors.New("duplicate role")
+)
+
+// Role names are short lowercase identifiers, for example "editor".
+var RoleNameRX = regexp.MustCompile("^[a-z][a-z0-9_-]*$")
+
+// A Role is a named bundle of permission codes which can be assigned to users.
+type Role struct {
+	ID          int64       `json:"id"`
+	Name        string      `json:"name"`
+	Permissions Permissions `json:"permissions"`
+}
+
+func ValidateRoleName(v *validator.Validator, name string) {
+	v.Check(name != "", "name", "must be provided")
+	v.Check(len(name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(validator.Matches(name, RoleNameRX), "name", "must only contain lowercase letters, digits, hyphens and underscores")
+}
+
+func ValidateRole(v *validator.Validator, role *Role) {
+	ValidateRoleName(v, role.Name)
+	v.Check(role.Permissions != nil, "permissions", "must be provided")
+	v.Check(validator.Unique(role.Permissions), "permissions", "must not contain duplicate values")
+	for _, code := range role.Permissions {
+		if !validator.Matches(code, PermissionCodeRX) {
+			v.AddError("permissions", "must all be in the format resource:action")
+			break
+		}
+	}
+}
+
+func ValidateRoleNames(v *validator.Validator, names []string) {
+	v.Check(names != nil, "roles", "must be provided")
+	v.Check(len(names) >= 1, "roles", "must contain at least 1 role")
+	v.Check(validator.Unique(names), "roles", "must not contain duplicate values")
+}
+
+// Define the RoleModel type.
+type RoleModel struct {
+	DB    *sql.DB
+	Cache *PermissionCache
+}
+
+// The GetAll() method returns every role along with its permission codes, ordered by
+// name.
+func (m RoleModel) GetAll() ([]*Role, error) {
+	query := `
+	SELECT roles.id, roles.name, COALESCE(array_agg(permissions.code ORDER BY permissions.code) FILTER (WHERE permissions.code IS NOT NULL), '{}')
+	FROM roles
+	LEFT JOIN roles_permissions ON roles_permissions.role_id = roles.id
+	LEFT JOIN permissions ON roles_permissions.permission_id = permissions.id
+	GROUP BY roles.id
+	ORDER BY roles.name`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	roles := []*Role{}
+	for rows.Next() {
+		var role Role
+		err := rows.Scan(&role.ID, &role.Name, pq.Array(&role.Permissions))
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, &role)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// The Get() method returns a single role along with its permission codes.
+func (m RoleModel) Get(id int64) (*Role, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+	SELECT roles.id, roles.name, COALESCE(array_agg(permissions.code ORDER BY permissions.code) FILTER (WHERE permissions.code IS NOT NULL), '{}')
+	FROM roles
+	LEFT JOIN roles_permissions ON roles_permissions.role_id = roles.id
+	LEFT JOIN permissions ON roles_permissions.permission_id = permissions.id
+	WHERE roles.id = $1
+	GROUP BY roles.id`
+	var role Role
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&role.ID, &role.Name, pq.Array(&role.Permissions))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &role, nil
+}
+
+// The Insert() method creates a new role and assigns its permission codes in a single
+// transaction, so a failure never leaves a role without its permissions. If a role
+// with the same name already exists we return an ErrDuplicateRole error.
+func (m RoleModel) Insert(role *Role) error {
+	query := `
+	INSERT INTO roles (name)
+	VALUES ($1)
+	RETURNING id`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = tx.QueryRowContext(ctx, query, role.Name).Scan(&role.ID)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "roles_name_key"`:
+			return ErrDuplicateRole
+		default:
+			return err
+		}
+	}
+	err = setRolePermissions(ctx, tx, role.ID, role.Permissions)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// The SetPermissions() method replaces the permission codes for a role. The old codes
+// are removed and the new ones added in a single transaction, so users holding the
+// role never see a partial set.
+func (m RoleModel) SetPermissions(roleID int64, codes ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = setRolePermissions(ctx, tx, roleID, codes)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	// We don't know which users hold the role, so drop every cached entry.
+	m.Cache.InvalidateAll()
+	return nil
+}
+
+// The setRolePermissions() helper replaces the permission codes for a role within an
+// existing transaction.
+func setRolePermissions(ctx context.Context, tx *sql.Tx, roleID int64, codes []string) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM roles_permissions WHERE role_id = $1`, roleID)
+	if err != nil {
+		return err
+	}
+	query := `
+	INSERT INTO roles_permissions
+	SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+	_, err = tx.ExecContext(ctx, query, roleID, pq.Array(codes))
+	return err
+}
+
+// The Delete() method removes a role. The users_roles and roles_permissions rows for
+// the role are removed by ON DELETE CASCADE.
+func (m RoleModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `
+	DELETE FROM roles
+	WHERE id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	m.Cache.InvalidateAll()
+	return nil
+}
+
+// The GetAllForUser() method returns the names of all roles held by a specific user.
+func (m RoleModel) GetAllForUser(userID int64) ([]string, error) {
+	query := `
+	SELECT roles.name
+	FROM roles
+	INNER JOIN users_roles ON users_roles.role_id = roles.id
+	WHERE users_roles.user_id = $1
+	ORDER BY roles.name`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	roles := []string{}
+	for rows.Next() {
+		var role string
+		err := rows.Scan(&role)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// The AddForUser() method assigns the named roles to a specific user. Roles which the
+// user already holds are left as they are.
+func (m RoleModel) AddForUser(userID int64, names ...string) error {
+	query := `
+	INSERT INTO users_roles
+	SELECT $1, roles.id FROM roles WHERE roles.name = ANY($2)
+	ON CONFLICT DO NOTHING`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(names))
+	if err != nil {
+		return err
+	}
+	m.Cache.Invalidate(userID)
+	return nil
+}
+
+// The RemoveForUser() method takes the named roles away from a specific user.
+func (m RoleModel) RemoveForUser(userID int64, names ...string) error {
+	query := `
+	DELETE FROM users_roles
+	USING roles
+	WHERE users_roles.role_id = roles.id
+	AND users_roles.user_id = $1
+	AND roles.name = ANY($2)`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(names))
+	if err != nil {
+		return err
+	}
+	m.Cache.Invalidate(userID)
+	return nil
+}
@@ -0,0 +1,102 @@
+package models
+
+import (
+	"cinemaGo/pkg/validator"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// The types of seat that a hall can have.
+const (
+	SeatTypeStandard   = "standard"
+	SeatTypeVIP        = "vip"
+	SeatTypeWheelchair = "wheelchair"
+)
+
+var SeatTypes = []string{SeatTypeStandard, SeatTypeVIP, SeatTypeWheelchair}
+
+// Row labels are short, like "A" or "AA", and are shown on tickets.
+var RowLabelRX = regexp.MustCompile(`^[A-Z0-9]{1,3}$`)
+
+// A SeatingLayout describes the seats in a hall, row by row from the front. It is stored
+// as JSON in a single column, as it is always read and written as a whole.
+type SeatingLayout struct {
+	Rows []SeatRow `json:"rows"`
+}
+
+// A SeatRow is a labelled row of seats, listed in the order they appear from left to
+// right as seen from the screen. Seat numbers don't need to be consecutive, so gaps for
+// aisles can be left out.
+type SeatRow struct {
+	Label string `json:"label"`
+	Seats []Seat `json:"seats"`
+}
+
+// A Seat is a single seat in a row. Blocked seats are part of the layout but can't be
+// sold, for example because they are broken or kept free for distancing.
+type Seat struct {
+	Number  int    `json:"number"`
+	Type    string `json:"type"`
+	Blocked bool   `json:"blocked,omitempty"`
+}
+
+// The Capacity() method returns the number of seats which aren't blocked.
+func (l SeatingLayout) Capacity() int {
+	capacity := 0
+	for _, row := range l.Rows {
+		for _, seat := range row.Seats {
+			if !seat.Blocked {
+				capacity++
+			}
+		}
+	}
+	return capacity
+}
+
+// The Value() method implements the driver.Valuer interface, so that a SeatingLayout
+// can be passed directly as a query argument for a jsonb column.
+func (l SeatingLayout) Value() (driver.Value, error) {
+	return json.Marshal(l)
+}
+
+// The Scan() method implements the sql.Scanner interface, so that a jsonb column can be
+// scanned directly into a SeatingLayout.
+func (l *SeatingLayout) Scan(src interface{}) error {
+	data, ok := src.([]byte)
+	if !ok {
+		return errors.New("seating layout must be scanned from a jsonb column")
+	}
+	return json.Unmarshal(data, l)
+}
+
+// ValidateSeatingLayout() checks that a layout has between 1 and 50 uniquely labelled
+// rows of between 1 and 100 uniquely numbered seats each, that every seat has a known
+// type, and that at least one seat isn't blocked. Errors are all reported under the
+// "layout" key, naming the row at fault.
+func ValidateSeatingLayout(v *validator.Validator, layout SeatingLayout) {
+	v.Check(layout.Rows != nil, "layout", "must be provided")
+	v.Check(len(layout.Rows) >= 1, "layout", "must contain at least 1 row")
+	v.Check(len(layout.Rows) <= 50, "layout", "must not contain more than 50 rows")
+	labels := make([]string, 0, len(layout.Rows))
+	for i, row := range layout.Rows {
+		if !validator.Matches(row.Label, RowLabelRX) {
+			v.AddError("layout", fmt.Sprintf("row %d must have a label of 1 to 3 capital letters or digits", i+1))
+			return
+		}
+		labels = append(labels, row.Label)
+		v.Check(len(row.Seats) >= 1, "layout", fmt.Sprintf("row %s must contain at least 1 seat", row.Label))
+		v.Check(len(row.Seats) <= 100, "layout", fmt.Sprintf("row %s must not contain more than 100 seats", row.Label))
+		numbers := make(map[int]bool, len(row.Seats))
+		for _, seat := range row.Seats {
+			v.Check(seat.Number >= 1 && seat.Number <= 999, "layout", fmt.Sprintf("row %s must only have seat numbers between 1 and 999", row.Label))
+			v.Check(!numbers[seat.Number], "layout", fmt.Sprintf("row %s must not contain duplicate seat numbers", row.Label))
+			v.Check(validator.In(seat.Type, SeatTypes...), "layout", fmt.Sprintf("row %s seat %d must have a type of standard, vip or wheelchair", row.Label, seat.Number))
+			numbers[seat.Number] = true
+		}
+	}
+	v.Check(validator.Unique(labels), "layout", "must not contain duplicate row labels")
+	v.Check(layout.Capacity() >= 1, "layout", "must contain at least 1 seat which isn't blocked")
+}
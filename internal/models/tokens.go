@@ -7,21 +7,50 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
+	"errors"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication" // Include a new authentication scope.
+	ScopePasswordReset  = "password-reset"
+	ScopeEmailChange    = "email-change"
+	ScopeMFA            = "mfa"
+	ScopeRefresh        = "refresh"
+)
+
+var (
+	ErrRefreshTokenReused = errors.New("refresh token reused")
 )
 
 // Add struct tags to control how the struct appears when encoded to JSON.
+// The ID, CreatedAt, LastUsedAt, UserAgent and ClientIP fields describe the session an
+// authentication token belongs to. The Hash is never included in JSON output, and the
+// Plaintext is only available at the moment the token is created.
+//
+// The authentication and refresh tokens issued for a single login share a Family and a
+// SessionID, which are carried over each time the refresh token is rotated. This lets
+// us revoke the whole session at once, and gives the session an ID which stays the same
+// however many times it is refreshed. Sessions started by a third-party OAuth client
+// also record the ClientID and the Permissions that the user granted to it.
 type Token struct {
-	Plaintext string    `json:"token"`
-	Hash      []byte    `json:"-"`
-	UserID    int64     `json:"-"`
-	Expiry    time.Time `json:"expiry"`
-	Scope     string    `json:"-"`
+	ID          int64       `json:"id"`
+	Plaintext   string      `json:"token,omitempty"`
+	Hash        []byte      `json:"-"`
+	UserID      int64       `json:"-"`
+	CreatedAt   time.Time   `json:"created_at"`
+	LastUsedAt  *time.Time  `json:"last_used_at,omitempty"`
+	Expiry      time.Time   `json:"expiry"`
+	Scope       string      `json:"-"`
+	UserAgent   string      `json:"user_agent,omitempty"`
+	ClientIP    string      `json:"client_ip,omitempty"`
+	Family      []byte      `json:"-"`
+	SessionID   int64       `json:"-"`
+	ClientID    string      `json:"client_id,omitempty"`
+	Permissions Permissions `json:"permissions,omitempty"`
 }
 
 func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
@@ -67,9 +96,12 @@ func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
 }
 
-// Define the TokenModel type.
+// Define the TokenModel type. In JWT mode, revoked shares the JWTModel's in-memory
+// revocation list, so that sessions revoked here take effect straight away; otherwise
+// it is nil.
 type TokenModel struct {
-	DB *sql.DB
+	DB      *sql.DB
+	revoked *revocationList
 }
 
 // The New() method is a shortcut which creates a new Token struct and then inserts the
@@ -83,18 +115,373 @@ func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token,
 	return token, err
 }
 
+// The NewSession() method starts a new session for a user, returning a short-lived
+// authentication token and a longer-lived refresh token which can be exchanged for
+// new tokens when it runs out. Both tokens record the user agent and IP address of the
+// client that they were issued to.
+func (m TokenModel) NewSession(userID int64, accessTTL, refreshTTL time.Duration, userAgent, clientIP string) (*Token, *Token, error) {
+	session := &Token{UserID: userID, UserAgent: userAgent, ClientIP: clientIP}
+	return m.newSession(session, accessTTL, refreshTTL)
+}
+
+// The NewOAuthSession() method starts a session on behalf of a third-party OAuth
+// client. The tokens are limited to the permission codes that the user consented to,
+// and the refresh token can only be used by the same client.
+func (m TokenModel) NewOAuthSession(userID int64, clientID string, permissions Permissions, accessTTL, refreshTTL time.Duration, userAgent, clientIP string) (*Token, *Token, error) {
+	session := &Token{UserID: userID, UserAgent: userAgent, ClientIP: clientIP, ClientID: clientID, Permissions: permissions}
+	return m.newSession(session, accessTTL, refreshTTL)
+}
+
+func (m TokenModel) newSession(session *Token, accessTTL, refreshTTL time.Duration) (*Token, *Token, error) {
+	session.Family = make([]byte, 16)
+	_, err := rand.Read(session.Family)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+	// Session IDs are taken from the same sequence as token IDs, so they can't clash
+	// with the IDs of sessions from before session IDs were recorded, which are
+	// identified by the ID of their authentication token.
+	err = tx.QueryRowContext(ctx, `SELECT nextval(pg_get_serial_sequence('tokens', 'id'))`).Scan(&session.SessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = deleteExpiredSessionTokens(ctx, tx)
+	if err != nil {
+		return nil, nil, err
+	}
+	access, refresh, err := insertSessionTokens(ctx, tx, session, accessTTL, refreshTTL)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, nil, err
+	}
+	return access, refresh, nil
+}
+
+// The deleteExpiredSessionTokens() function clears out authentication and refresh
+// tokens which have expired, including refresh tokens which have been used. Used
+// refresh tokens are kept until then so that reuse can be detected, but after they
+// expire Rotate() won't accept them anyway.
+func deleteExpiredSessionTokens(ctx context.Context, tx *sql.Tx) error {
+	query := `
+	DELETE FROM tokens
+	WHERE scope = ANY($1) AND expiry <= $2`
+	_, err := tx.ExecContext(ctx, query, pq.Array([]string{ScopeAuthentication, ScopeRefresh}), time.Now())
+	return err
+}
+
+// The Rotate() method exchanges a refresh token for a new authentication token and
+// refresh token in the same family and session. The old refresh token is marked as
+// used rather than deleted, and any earlier authentication tokens in the family are
+// revoked. The clientID must match the OAuth client that the session was issued to,
+// or be empty for our own sessions.
+//
+// A refresh token should only ever be used once, so if a used one is presented again
+// we assume that it has been stolen. Since we can't tell whether the legitimate client
+// or the attacker holds the latest token, we revoke the entire family and return
+// ErrRefreshTokenReused, forcing the user to log in again. In JWT mode the session is
+// added to the revocation list first, as its JWT would otherwise remain valid until it
+// expires.
+func (m TokenModel) Rotate(refreshPlaintext, clientID string, accessTTL, refreshTTL time.Duration, userAgent, clientIP string) (*Token, *Token, error) {
+	refreshHash := sha256.Sum256([]byte(refreshPlaintext))
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+	// Lock the row, so that two concurrent requests with the same refresh token can't
+	// both rotate it.
+	query := `
+	SELECT user_id, family, COALESCE(session_id, id), used_at, COALESCE(client_id, ''), permissions
+	FROM tokens
+	WHERE hash = $1 AND scope = $2 AND expiry > $3 AND COALESCE(client_id, '') = $4
+	FOR UPDATE`
+	session := &Token{UserAgent: userAgent, ClientIP: clientIP}
+	var usedAt *time.Time
+	err = tx.QueryRowContext(ctx, query, refreshHash[:], ScopeRefresh, time.Now(), clientID).Scan(
+		&session.UserID,
+		&session.Family,
+		&session.SessionID,
+		&usedAt,
+		&session.ClientID,
+		pq.Array(&session.Permissions),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+	if usedAt != nil {
+		var revokedExpiry sql.NullTime
+		if m.revoked != nil {
+			query = `
+			INSERT INTO revoked_tokens (session_id, expiry)
+			SELECT $1, max(expiry) FROM tokens
+			WHERE family = $2 AND scope = $3 AND expiry > $4
+			HAVING count(*) > 0
+			ON CONFLICT DO NOTHING
+			RETURNING expiry`
+			err = tx.QueryRowContext(ctx, query, session.SessionID, session.Family, ScopeAuthentication, time.Now()).Scan(&revokedExpiry)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return nil, nil, err
+			}
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE family = $1`, session.Family)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = tx.Commit()
+		if err != nil {
+			return nil, nil, err
+		}
+		if revokedExpiry.Valid {
+			m.revoked.add(session.SessionID, revokedExpiry.Time)
+		}
+		return nil, nil, ErrRefreshTokenReused
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE tokens SET used_at = $1 WHERE hash = $2`, time.Now(), refreshHash[:])
+	if err != nil {
+		return nil, nil, err
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE family = $1 AND scope = $2`, session.Family, ScopeAuthentication)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = deleteExpiredSessionTokens(ctx, tx)
+	if err != nil {
+		return nil, nil, err
+	}
+	access, refresh, err := insertSessionTokens(ctx, tx, session, accessTTL, refreshTTL)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, nil, err
+	}
+	return access, refresh, nil
+}
+
+// The insertSessionTokens() function generates and inserts an authentication token and
+// a refresh token as part of a transaction. The user, client details, family, session
+// ID and OAuth grant are copied from the session argument.
+func insertSessionTokens(ctx context.Context, tx *sql.Tx, session *Token, accessTTL, refreshTTL time.Duration) (*Token, *Token, error) {
+	access, err := insertSessionToken(ctx, tx, session, accessTTL, ScopeAuthentication)
+	if err != nil {
+		return nil, nil, err
+	}
+	refresh, err := insertSessionToken(ctx, tx, session, refreshTTL, ScopeRefresh)
+	if err != nil {
+		return nil, nil, err
+	}
+	return access, refresh, nil
+}
+
+func insertSessionToken(ctx context.Context, tx *sql.Tx, session *Token, ttl time.Duration, scope string) (*Token, error) {
+	token, err := generateToken(session.UserID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+	token.UserAgent = session.UserAgent
+	token.ClientIP = session.ClientIP
+	token.Family = session.Family
+	token.SessionID = session.SessionID
+	token.ClientID = session.ClientID
+	token.Permissions = session.Permissions
+	query := `
+	INSERT INTO tokens (hash, user_id, expiry, scope, user_agent, client_ip, family, session_id, client_id, permissions)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, ''), $10)
+	RETURNING created_at`
+	args := []interface{}{
+		token.Hash,
+		token.UserID,
+		token.Expiry,
+		token.Scope,
+		token.UserAgent,
+		token.ClientIP,
+		token.Family,
+		token.SessionID,
+		token.ClientID,
+		pq.Array([]string(token.Permissions)),
+	}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&token.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	// Session tokens are identified by their session ID, which is what the sessions
+	// endpoints use, rather than by the ID of the row.
+	token.ID = token.SessionID
+	return token, nil
+}
+
 // Insert() adds the data for a specific token to the tokens table.
 func (m TokenModel) Insert(token *Token) error {
 	query := `
-	INSERT INTO tokens (hash, user_id, expiry, scope)
-	VALUES ($1, $2, $3, $4)`
-	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope}
+	INSERT INTO tokens (hash, user_id, expiry, scope, user_agent, client_ip)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id, created_at`
+	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope, token.UserAgent, token.ClientIP}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&token.ID, &token.CreatedAt)
+}
+
+// GetAllForUser() returns all unexpired tokens for a specific user and scope, most
+// recently created first.
+func (m TokenModel) GetAllForUser(scope string, userID int64) ([]*Token, error) {
+	query := `
+	SELECT id, hash, user_id, created_at, last_used_at, expiry, scope, user_agent, client_ip, COALESCE(client_id, ''), permissions
+	FROM tokens
+	WHERE scope = $1 AND user_id = $2 AND expiry > $3
+	ORDER BY created_at DESC, id DESC`
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	_, err := m.DB.ExecContext(ctx, query, args...)
+	rows, err := m.DB.QueryContext(ctx, query, scope, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tokens := []*Token{}
+	for rows.Next() {
+		var token Token
+		err := rows.Scan(
+			&token.ID,
+			&token.Hash,
+			&token.UserID,
+			&token.CreatedAt,
+			&token.LastUsedAt,
+			&token.Expiry,
+			&token.Scope,
+			&token.UserAgent,
+			&token.ClientIP,
+			&token.ClientID,
+			pq.Array(&token.Permissions),
+		)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, &token)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// GetAllSessionsForUser() returns the active sessions for a specific user, most recently
+// started first. A session is active while it has an unexpired authentication token or
+// an unused, unexpired refresh token, and is identified by its session ID, so it stays
+// in the list with the same ID however many times it is refreshed. The client details
+// are taken from the most recently issued token, and the expiry is when the session
+// will end if it isn't refreshed.
+func (m TokenModel) GetAllSessionsForUser(userID int64) ([]*Token, error) {
+	query := `
+	SELECT id, created_at, last_used_at, expiry, user_agent, client_ip, client_id, permissions
+	FROM (
+		SELECT DISTINCT ON (COALESCE(session_id, id))
+			COALESCE(session_id, id) AS id,
+			min(created_at) OVER w AS created_at,
+			max(last_used_at) OVER w AS last_used_at,
+			max(expiry) FILTER (WHERE used_at IS NULL) OVER w AS expiry,
+			user_agent,
+			client_ip,
+			COALESCE(client_id, '') AS client_id,
+			permissions
+		FROM tokens
+		WHERE scope = ANY($1) AND user_id = $2
+		WINDOW w AS (PARTITION BY COALESCE(session_id, id))
+		ORDER BY COALESCE(session_id, id), tokens.id DESC
+	) AS sessions
+	WHERE expiry > $3
+	ORDER BY created_at DESC, id DESC`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array([]string{ScopeAuthentication, ScopeRefresh}), userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sessions := []*Token{}
+	for rows.Next() {
+		session := Token{UserID: userID, Scope: ScopeAuthentication}
+		err := rows.Scan(
+			&session.ID,
+			&session.CreatedAt,
+			&session.LastUsedAt,
+			&session.Expiry,
+			&session.UserAgent,
+			&session.ClientIP,
+			&session.ClientID,
+			pq.Array(&session.Permissions),
+		)
+		if err != nil {
+			return nil, err
+		}
+		session.SessionID = session.ID
+		sessions = append(sessions, &session)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// UpdateLastUsed() records that a token has just been used. To avoid writing to the
+// database on every single request, the timestamp is only moved forward if it is more
+// than a minute old.
+func (m TokenModel) UpdateLastUsed(tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+	query := `
+	UPDATE tokens
+	SET last_used_at = $1
+	WHERE hash = $2 AND (last_used_at IS NULL OR last_used_at < $3)`
+	now := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, now, tokenHash[:], now.Add(-time.Minute))
 	return err
 }
 
+// DeleteSession() revokes a single session by its session ID, making sure that it
+// belongs to the given user. Both the authentication and refresh tokens for the
+// session are deleted.
+func (m TokenModel) DeleteSession(userID, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `
+	DELETE FROM tokens
+	WHERE scope = ANY($1) AND user_id = $2 AND COALESCE(session_id, id) = $3`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, pq.Array([]string{ScopeAuthentication, ScopeRefresh}), userID, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
 // DeleteAllForUser() deletes all tokens for a specific user and scope.
 func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
 	query := `
@@ -105,3 +492,51 @@ func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
 	_, err := m.DB.ExecContext(ctx, query, scope, userID)
 	return err
 }
+
+// DeleteAllSessionsForUser() revokes every session for a specific user, by deleting
+// all of their authentication and refresh tokens.
+func (m TokenModel) DeleteAllSessionsForUser(userID int64) error {
+	query := `
+	DELETE FROM tokens
+	WHERE scope = ANY($1) AND user_id = $2`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, pq.Array([]string{ScopeAuthentication, ScopeRefresh}), userID)
+	return err
+}
+
+// GetSessionID() returns the session ID of an unexpired authentication token,
+// identified by its plaintext value.
+func (m TokenModel) GetSessionID(tokenPlaintext string) (int64, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+	query := `
+	SELECT COALESCE(session_id, id)
+	FROM tokens
+	WHERE hash = $1 AND scope = $2 AND expiry > $3`
+	var id int64
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, tokenHash[:], ScopeAuthentication, time.Now()).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+	return id, nil
+}
+
+// DeleteOtherSessionsForUser() revokes every session for a specific user apart from
+// the one with the given session ID. This lets a user sign out of every other device
+// while staying logged in on the current one.
+func (m TokenModel) DeleteOtherSessionsForUser(userID, exceptID int64) error {
+	query := `
+	DELETE FROM tokens
+	WHERE scope = ANY($1) AND user_id = $2 AND COALESCE(session_id, id) <> $3`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, pq.Array([]string{ScopeAuthentication, ScopeRefresh}), userID, exceptID)
+	return err
+}
@@ -6,8 +6,10 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
+	"github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -25,7 +27,15 @@ type User struct {
 	Email     string    `json:"email"`
 	Password  password  `json:"-"`
 	Activated bool      `json:"activated"`
-	Version   int       `json:"-"`
+	// PendingEmail holds a new email address that the user has asked to change to, but
+	// which hasn't been confirmed yet. It is empty if there is no change in progress.
+	PendingEmail string `json:"pending_email,omitempty"`
+	// SuspendedAt is set when an admin suspends the account. If SuspendedUntil is nil
+	// the suspension lasts until it is lifted by an admin.
+	SuspendedAt      *time.Time `json:"suspended_at,omitempty"`
+	SuspendedUntil   *time.Time `json:"suspended_until,omitempty"`
+	SuspensionReason string     `json:"suspension_reason,omitempty"`
+	Version          int        `json:"-"`
 }
 
 // Check if a User instance is the AnonymousUser.
@@ -33,6 +43,31 @@ func (u *User) IsAnonymous() bool {
 	return u == AnonymousUser
 }
 
+// Check if a User is currently suspended. A suspension with an end time in the past
+// has expired and no longer applies.
+func (u *User) IsSuspended() bool {
+	if u.SuspendedAt == nil {
+		return false
+	}
+	return u.SuspendedUntil == nil || time.Now().Before(*u.SuspendedUntil)
+}
+
+// Suspend() marks the user as suspended from now, with the given reason and optional
+// end time.
+func (u *User) Suspend(reason string, until *time.Time) {
+	now := time.Now()
+	u.SuspendedAt = &now
+	u.SuspendedUntil = until
+	u.SuspensionReason = reason
+}
+
+// Unsuspend() lifts any suspension on the user.
+func (u *User) Unsuspend() {
+	u.SuspendedAt = nil
+	u.SuspendedUntil = nil
+	u.SuspensionReason = ""
+}
+
 type password struct {
 	plaintext *string
 	hash      []byte
@@ -48,6 +83,16 @@ func (p *password) Set(plaintextPassword string) error {
 	return nil
 }
 
+// SetRandom() sets an unguessable password, for users who sign in some other way. They
+// can still choose a password of their own with a password reset.
+func (p *password) SetRandom() error {
+	plaintext, err := randomString(32)
+	if err != nil {
+		return err
+	}
+	return p.Set(plaintext)
+}
+
 func (p *password) Matches(plaintextPassword string) (bool, error) {
 	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
 	if err != nil {
@@ -71,6 +116,14 @@ func ValidatePasswordPlaintext(v *validator.Validator, password string) {
 	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
 }
 
+func ValidateSuspension(v *validator.Validator, reason string, until *time.Time) {
+	v.Check(reason != "", "reason", "must be provided")
+	v.Check(len(reason) <= 500, "reason", "must not be more than 500 bytes long")
+	if until != nil {
+		v.Check(until.After(time.Now()), "until", "must be in the future")
+	}
+}
+
 func ValidateUser(v *validator.Validator, user *User) {
 	v.Check(user.Name != "", "name", "must be provided")
 	v.Check(len(user.Name) <= 500, "name", "must not be more than 500 bytes long")
@@ -119,9 +172,44 @@ func (m UserModel) Insert(user *User) error {
 	return nil
 }
 
+func (m UserModel) Get(id int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+	SELECT id, created_at, name, email, password_hash, activated, pending_email, suspended_at, suspended_until, suspension_reason, version
+	FROM users
+	WHERE id = $1`
+	var user User
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.PendingEmail,
+		&user.SuspendedAt,
+		&user.SuspendedUntil,
+		&user.SuspensionReason,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
 func (m UserModel) GetByEmail(email string) (*User, error) {
 	query := `
-	SELECT id, created_at, name, email, password_hash, activated, version
+	SELECT id, created_at, name, email, password_hash, activated, pending_email, suspended_at, suspended_until, suspension_reason, version
 	FROM users
 	WHERE email = $1`
 	var user User
@@ -134,6 +222,10 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.PendingEmail,
+		&user.SuspendedAt,
+		&user.SuspendedUntil,
+		&user.SuspensionReason,
 		&user.Version,
 	)
 	if err != nil {
@@ -150,14 +242,19 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 func (m UserModel) Update(user *User) error {
 	query := `
 	UPDATE users
-	SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
-	WHERE id = $5 AND version = $6
+	SET name = $1, email = $2, password_hash = $3, activated = $4, pending_email = $5,
+	suspended_at = $6, suspended_until = $7, suspension_reason = $8, version = version + 1
+	WHERE id = $9 AND version = $10
 	RETURNING version`
 	args := []interface{}{
 		user.Name,
 		user.Email,
 		user.Password.hash,
 		user.Activated,
+		user.PendingEmail,
+		user.SuspendedAt,
+		user.SuspendedUntil,
+		user.SuspensionReason,
 		user.ID,
 		user.Version,
 	}
@@ -183,7 +280,7 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 	// Set up the SQL query.
 	query := `
-	SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+	SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.pending_email, users.suspended_at, users.suspended_until, users.suspension_reason, users.version
 	FROM users
 	INNER JOIN tokens
 	ON users.id = tokens.user_id
@@ -207,6 +304,10 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.PendingEmail,
+		&user.SuspendedAt,
+		&user.SuspendedUntil,
+		&user.SuspensionReason,
 		&user.Version,
 	)
 	if err != nil {
@@ -220,3 +321,140 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 	// Return the matching user.
 	return &user, nil
 }
+
+// The GetForAuthenticationToken() method works like GetForToken() for authentication
+// tokens, but also returns the permission codes that the token is limited to. These
+// are nil for our own sessions, which carry all of the user's permissions, and set for
+// sessions started by a third-party OAuth client.
+func (m UserModel) GetForAuthenticationToken(tokenPlaintext string) (*User, Permissions, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+	query := `
+	SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.pending_email, users.suspended_at, users.suspended_until, users.suspension_reason, users.version, tokens.permissions
+	FROM users
+	INNER JOIN tokens
+	ON users.id = tokens.user_id
+	WHERE tokens.hash = $1
+	AND tokens.scope = $2
+	AND tokens.expiry > $3`
+	args := []interface{}{tokenHash[:], ScopeAuthentication, time.Now()}
+	var user User
+	var permissions Permissions
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.PendingEmail,
+		&user.SuspendedAt,
+		&user.SuspendedUntil,
+		&user.SuspensionReason,
+		&user.Version,
+		pq.Array(&permissions),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+	return &user, permissions, nil
+}
+
+// Delete() removes a user record. The foreign keys on the tokens and
+// users_permissions tables are declared with ON DELETE CASCADE, so the user's tokens
+// and permission grants are removed along with it.
+//
+// The user's comments are kept so that other users' replies to them stay in their
+// threads. In the same transaction we mark them as deleted, which redacts them, and
+// the foreign key then sets their user_id to NULL.
+func (m UserModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	query := `
+	UPDATE comments
+	SET body = '', deleted_at = COALESCE(deleted_at, NOW()), version = version + 1
+	WHERE user_id = $1`
+	_, err = tx.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	query = `
+	DELETE FROM users
+	WHERE id = $1`
+	result, err := tx.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return tx.Commit()
+}
+
+// The GetAll() method returns a paginated list of users. The email filter matches any
+// part of the address, the name filter uses the same full-text search as movie titles,
+// and a nil activated filter matches both activated and unactivated users.
+func (m UserModel) GetAll(email, name string, activated *bool, filters Filters) ([]*User, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), id, created_at, name, email, password_hash, activated, pending_email, suspended_at, suspended_until, suspension_reason, version
+	FROM users
+	WHERE (strpos(email, $1) > 0 OR $1 = '')
+	AND (to_tsvector('simple', name) @@ plainto_tsquery('simple', $2) OR $2 = '')
+	AND ($3::boolean IS NULL OR activated = $3)
+	ORDER BY %s %s, id ASC
+	LIMIT $4 OFFSET $5`, filters.sortColumn(), filters.sortDirection())
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	args := []interface{}{email, name, activated, filters.limit(), filters.offset()}
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	users := []*User{}
+	for rows.Next() {
+		var user User
+		err := rows.Scan(
+			&totalRecords,
+			&user.ID,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Email,
+			&user.Password.hash,
+			&user.Activated,
+			&user.PendingEmail,
+			&user.SuspendedAt,
+			&user.SuspendedUntil,
+			&user.SuspensionReason,
+			&user.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		users = append(users, &user)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return users, metadata, nil
+}
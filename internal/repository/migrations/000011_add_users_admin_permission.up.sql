@@ -0,0 +1,9 @@
+INSERT INTO permissions (code)
+VALUES
+    ('users:admin')
+ON CONFLICT DO NOTHING;
+-- Give the new permission to the admin role as well.
+INSERT INTO roles_permissions
+SELECT roles.id, permissions.id FROM roles, permissions
+WHERE roles.name = 'admin' AND permissions.code = 'users:admin'
+ON CONFLICT DO NOTHING;
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS suspended_at timestamp(0) with time zone;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS suspended_until timestamp(0) with time zone;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS suspension_reason text NOT NULL DEFAULT '';
\ No newline at end of file
@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    session_id bigint PRIMARY KEY,
+    expiry timestamp(0) with time zone NOT NULL
+);
\ No newline at end of file
@@ -0,0 +1,4 @@
+DELETE FROM permissions WHERE code = 'comments:moderate';
+DROP TABLE IF EXISTS comment_moderation_actions;
+DROP TABLE IF EXISTS comment_flags;
+DROP TABLE IF EXISTS comments;
\ No newline at end of file
@@ -0,0 +1,44 @@
+CREATE TABLE IF NOT EXISTS comments (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    parent_id bigint REFERENCES comments ON DELETE CASCADE,
+    depth integer NOT NULL DEFAULT 0,
+    body text NOT NULL,
+    deleted_at timestamp(0) with time zone,
+    hidden_at timestamp(0) with time zone,
+    hidden_by bigint REFERENCES users ON DELETE SET NULL,
+    version integer NOT NULL DEFAULT 1
+);
+CREATE INDEX IF NOT EXISTS comments_movie_id_idx ON comments (movie_id, created_at);
+CREATE INDEX IF NOT EXISTS comments_parent_id_idx ON comments (parent_id);
+CREATE TABLE IF NOT EXISTS comment_flags (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    comment_id bigint NOT NULL REFERENCES comments ON DELETE CASCADE,
+    user_id bigint REFERENCES users ON DELETE CASCADE,
+    reason text NOT NULL,
+    resolved_at timestamp(0) with time zone,
+    CONSTRAINT comment_flags_comment_user_key UNIQUE (comment_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS comment_flags_unresolved_idx ON comment_flags (comment_id) WHERE resolved_at IS NULL;
+CREATE TABLE IF NOT EXISTS comment_moderation_actions (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    comment_id bigint NOT NULL REFERENCES comments ON DELETE CASCADE,
+    moderator_id bigint REFERENCES users ON DELETE SET NULL,
+    action text NOT NULL,
+    reason text NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS comment_moderation_actions_comment_id_idx ON comment_moderation_actions (comment_id);
+INSERT INTO permissions (code)
+VALUES
+    ('comments:moderate')
+ON CONFLICT DO NOTHING;
+-- Give the new permission to the admin role as well.
+INSERT INTO roles_permissions
+SELECT roles.id, permissions.id FROM roles, permissions
+WHERE roles.name = 'admin' AND permissions.code = 'comments:moderate'
+ON CONFLICT DO NOTHING;
\ No newline at end of file
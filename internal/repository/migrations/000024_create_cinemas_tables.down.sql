@@ -0,0 +1,3 @@
+DELETE FROM permissions WHERE code = 'cinemas:write';
+DROP TABLE IF EXISTS halls;
+DROP TABLE IF EXISTS cinemas;
\ No newline at end of file
@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS cinemas (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name text NOT NULL,
+    address text NOT NULL,
+    city text NOT NULL,
+    version integer NOT NULL DEFAULT 1
+);
+CREATE INDEX IF NOT EXISTS cinemas_name_idx ON cinemas USING GIN (to_tsvector('simple', name));
+CREATE TABLE IF NOT EXISTS halls (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    cinema_id bigint NOT NULL REFERENCES cinemas ON DELETE CASCADE,
+    name text NOT NULL,
+    layout jsonb NOT NULL,
+    version integer NOT NULL DEFAULT 1,
+    CONSTRAINT halls_cinema_name_key UNIQUE (cinema_id, name)
+);
+INSERT INTO permissions (code)
+VALUES
+    ('cinemas:write')
+ON CONFLICT DO NOTHING;
+-- Give the new permission to the admin role as well.
+INSERT INTO roles_permissions
+SELECT roles.id, permissions.id FROM roles, permissions
+WHERE roles.name = 'admin' AND permissions.code = 'cinemas:write'
+ON CONFLICT DO NOTHING;
\ No newline at end of file
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS tokens_expiry_idx;
+DROP INDEX IF EXISTS tokens_session_id_idx;
+ALTER TABLE tokens DROP COLUMN IF EXISTS session_id;
\ No newline at end of file
@@ -0,0 +1,6 @@
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS session_id bigint;
+UPDATE tokens SET session_id = families.id
+FROM (SELECT family, min(id) AS id FROM tokens WHERE family IS NOT NULL GROUP BY family) AS families
+WHERE tokens.family = families.family;
+CREATE INDEX IF NOT EXISTS tokens_session_id_idx ON tokens (session_id);
+CREATE INDEX IF NOT EXISTS tokens_expiry_idx ON tokens (expiry);
\ No newline at end of file
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS comments_user_id_idx;
+ALTER TABLE comments DROP CONSTRAINT IF EXISTS comments_parent_id_fkey;
+ALTER TABLE comments ADD CONSTRAINT comments_parent_id_fkey FOREIGN KEY (parent_id) REFERENCES comments ON DELETE CASCADE;
+ALTER TABLE comments DROP CONSTRAINT IF EXISTS comments_user_id_fkey;
+ALTER TABLE comments ADD CONSTRAINT comments_user_id_fkey FOREIGN KEY (user_id) REFERENCES users ON DELETE CASCADE;
+DELETE FROM comments WHERE user_id IS NULL;
+ALTER TABLE comments ALTER COLUMN user_id SET NOT NULL;
\ No newline at end of file
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS oidc_logins_expiry_idx;
\ No newline at end of file
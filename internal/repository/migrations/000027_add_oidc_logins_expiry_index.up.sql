@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS oidc_logins_expiry_idx ON oidc_logins (expiry);
\ No newline at end of file
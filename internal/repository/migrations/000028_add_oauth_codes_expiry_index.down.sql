@@ -0,0 +1 @@
+DROP INDEX IF EXISTS oauth_codes_expiry_idx;
\ No newline at end of file
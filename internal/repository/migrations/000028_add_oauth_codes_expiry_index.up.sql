@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS oauth_codes_expiry_idx ON oauth_codes (expiry);
\ No newline at end of file
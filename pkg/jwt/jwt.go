@@ -0,0 +1,206 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The signing algorithms that we support, using their names from RFC 7518 and RFC 8037.
+const (
+	HS256 = "HS256"
+	EdDSA = "EdDSA"
+)
+
+var (
+	ErrInvalidToken = errors.New("jwt: invalid token")
+	ErrExpiredToken = errors.New("jwt: token has expired")
+	ErrUnknownKey   = errors.New("jwt: unknown key ID")
+)
+
+var encoding = base64.RawURLEncoding
+
+// A Key is a named signing key. The ID is included in the header of every token that
+// the key signs, so that keys can be rotated: a new key is added and used for signing,
+// while the old one is kept around to verify tokens issued before the switch.
+type Key struct {
+	ID        string
+	Algorithm string
+	secret    []byte
+	private   ed25519.PrivateKey
+	public    ed25519.PublicKey
+}
+
+// NewHMACKey returns an HS256 key. The secret must be at least 32 bytes long, which is
+// the size of the hash output.
+func NewHMACKey(id string, secret []byte) (*Key, error) {
+	if len(secret) < sha256.Size {
+		return nil, fmt.Errorf("jwt: HMAC secret for key %q must be at least %d bytes", id, sha256.Size)
+	}
+	return &Key{ID: id, Algorithm: HS256, secret: secret}, nil
+}
+
+// NewEd25519Key returns an EdDSA key from a 32 byte Ed25519 seed.
+func NewEd25519Key(id string, seed []byte) (*Key, error) {
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("jwt: Ed25519 seed for key %q must be %d bytes", id, ed25519.SeedSize)
+	}
+	private := ed25519.NewKeyFromSeed(seed)
+	return &Key{ID: id, Algorithm: EdDSA, private: private, public: private.Public().(ed25519.PublicKey)}, nil
+}
+
+// ParseKey parses a key in the form "id:algorithm:base64-key", as used for the
+// command-line flags. The key material is standard base-64 encoded, and is the HMAC
+// secret for HS256 or the Ed25519 seed for EdDSA.
+func ParseKey(spec string) (*Key, error) {
+	parts := strings.SplitN(spec, ":", 3)
+	if len(parts) != 3 || parts[0] == "" {
+		return nil, errors.New("jwt: key must be in the format id:algorithm:base64-key")
+	}
+	material, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("jwt: key %q is not valid base-64: %w", parts[0], err)
+	}
+	switch parts[1] {
+	case HS256:
+		return NewHMACKey(parts[0], material)
+	case EdDSA:
+		return NewEd25519Key(parts[0], material)
+	default:
+		return nil, fmt.Errorf("jwt: key %q has unsupported algorithm %q", parts[0], parts[1])
+	}
+}
+
+func (k *Key) sign(input []byte) []byte {
+	if k.Algorithm == EdDSA {
+		return ed25519.Sign(k.private, input)
+	}
+	mac := hmac.New(sha256.New, k.secret)
+	mac.Write(input)
+	return mac.Sum(nil)
+}
+
+func (k *Key) verify(input, signature []byte) bool {
+	if k.Algorithm == EdDSA {
+		return ed25519.Verify(k.public, input, signature)
+	}
+	return hmac.Equal(k.sign(input), signature)
+}
+
+// A KeySet holds every key that tokens are accepted from, and the one used to sign
+// new tokens.
+type KeySet struct {
+	keys    map[string]*Key
+	signing *Key
+}
+
+// NewKeySet returns a KeySet which signs with the key named signingKeyID. If
+// signingKeyID is empty the first key is used.
+func NewKeySet(signingKeyID string, keys ...*Key) (*KeySet, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("jwt: at least one key is required")
+	}
+	ks := &KeySet{keys: make(map[string]*Key)}
+	for _, key := range keys {
+		if _, exists := ks.keys[key.ID]; exists {
+			return nil, fmt.Errorf("jwt: duplicate key ID %q", key.ID)
+		}
+		ks.keys[key.ID] = key
+	}
+	if signingKeyID == "" {
+		signingKeyID = keys[0].ID
+	}
+	ks.signing = ks.keys[signingKeyID]
+	if ks.signing == nil {
+		return nil, fmt.Errorf("jwt: signing key %q not found", signingKeyID)
+	}
+	return ks, nil
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyID     string `json:"kid"`
+}
+
+// Claims holds the registered claims that we use. Times are in seconds since the Unix
+// epoch, as required by RFC 7519.
+type Claims struct {
+	Issuer   string `json:"iss,omitempty"`
+	Subject  string `json:"sub"`
+	ID       string `json:"jti,omitempty"`
+	IssuedAt int64  `json:"iat"`
+	Expiry   int64  `json:"exp"`
+}
+
+// IsJWT reports whether a bearer token looks like a JWT (three dot-separated parts),
+// rather than an opaque token.
+func IsJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// Sign returns a compact serialized JWT for the claims, signed with the signing key.
+func (ks *KeySet) Sign(claims Claims) (string, error) {
+	h, err := json.Marshal(header{Algorithm: ks.signing.Algorithm, Type: "JWT", KeyID: ks.signing.ID})
+	if err != nil {
+		return "", err
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	input := encoding.EncodeToString(h) + "." + encoding.EncodeToString(c)
+	return input + "." + encoding.EncodeToString(ks.signing.sign([]byte(input))), nil
+}
+
+// Verify checks the signature and expiry of a token and returns its claims. The key
+// is chosen by the kid header, and the alg header must match that key's algorithm, so
+// a token can't pick a weaker algorithm (or "none") for itself.
+func (ks *KeySet) Verify(token string, now time.Time) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+	var h header
+	err := decodeSegment(parts[0], &h)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	key, found := ks.keys[h.KeyID]
+	if !found {
+		return nil, ErrUnknownKey
+	}
+	if h.Algorithm != key.Algorithm {
+		return nil, ErrInvalidToken
+	}
+	signature, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if !key.verify([]byte(parts[0]+"."+parts[1]), signature) {
+		return nil, ErrInvalidToken
+	}
+	var claims Claims
+	err = decodeSegment(parts[1], &claims)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if now.Unix() >= claims.Expiry {
+		return nil, ErrExpiredToken
+	}
+	return &claims, nil
+}
+
+func decodeSegment(segment string, dst interface{}) error {
+	data, err := encoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, dst)
+}
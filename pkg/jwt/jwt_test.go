@@ -0,0 +1,247 @@
+package jwt
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var now = time.Unix(1700000000, 0)
+
+func newTestKeys(t *testing.T) (*Key, *Key) {
+	t.Helper()
+	hmacKey, err := NewHMACKey("hmac-1", bytes.Repeat([]byte("s"), 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	edKey, err := NewEd25519Key("ed-1", bytes.Repeat([]byte("e"), 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hmacKey, edKey
+}
+
+func testClaims() Claims {
+	return Claims{
+		Issuer:   "cinemago",
+		Subject:  "42",
+		ID:       "7",
+		IssuedAt: now.Unix(),
+		Expiry:   now.Add(15 * time.Minute).Unix(),
+	}
+}
+
+// The encodeToken() helper builds a token with an arbitrary header, signed with key, or
+// with an empty signature if key is nil.
+func encodeToken(t *testing.T, key *Key, h header, claims Claims) string {
+	t.Helper()
+	hj, err := json.Marshal(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cj, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := encoding.EncodeToString(hj) + "." + encoding.EncodeToString(cj)
+	if key == nil {
+		return input + "."
+	}
+	return input + "." + encoding.EncodeToString(key.sign([]byte(input)))
+}
+
+func TestSignVerify(t *testing.T) {
+	hmacKey, edKey := newTestKeys(t)
+	for _, signing := range []*Key{hmacKey, edKey} {
+		ks, err := NewKeySet(signing.ID, hmacKey, edKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, err := ks.Sign(testClaims())
+		if err != nil {
+			t.Fatalf("%s: Sign: %v", signing.Algorithm, err)
+		}
+		if !IsJWT(token) {
+			t.Errorf("%s: IsJWT(%q) = false", signing.Algorithm, token)
+		}
+		claims, err := ks.Verify(token, now)
+		if err != nil {
+			t.Fatalf("%s: Verify: %v", signing.Algorithm, err)
+		}
+		if *claims != testClaims() {
+			t.Errorf("%s: Verify = %+v; want %+v", signing.Algorithm, *claims, testClaims())
+		}
+	}
+}
+
+// Tokens signed with an old key must still verify after the signing key is rotated.
+func TestVerifyRotatedKey(t *testing.T) {
+	hmacKey, edKey := newTestKeys(t)
+	old, err := NewKeySet(hmacKey.ID, hmacKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := old.Sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := NewKeySet(edKey.ID, edKey, hmacKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rotated.Verify(token, now); err != nil {
+		t.Errorf("Verify with rotated key set: %v", err)
+	}
+}
+
+func TestVerifyTampered(t *testing.T) {
+	hmacKey, edKey := newTestKeys(t)
+	for _, signing := range []*Key{hmacKey, edKey} {
+		ks, err := NewKeySet(signing.ID, signing)
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, err := ks.Sign(testClaims())
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts := strings.Split(token, ".")
+
+		claims := testClaims()
+		claims.Subject = "1"
+		cj, err := json.Marshal(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		payload := parts[0] + "." + encoding.EncodeToString(cj) + "." + parts[2]
+
+		signature, err := encoding.DecodeString(parts[2])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature[0] ^= 0x01
+		badSignature := parts[0] + "." + parts[1] + "." + encoding.EncodeToString(signature)
+
+		tests := map[string]string{
+			"payload":         payload,
+			"signature":       badSignature,
+			"missing part":    parts[0] + "." + parts[1],
+			"bad encoding":    parts[0] + "." + parts[1] + ".!!!",
+			"empty signature": parts[0] + "." + parts[1] + ".",
+		}
+		for name, token := range tests {
+			_, err := ks.Verify(token, now)
+			if !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("%s: tampered %s: err = %v; want %v", signing.Algorithm, name, err, ErrInvalidToken)
+			}
+		}
+	}
+}
+
+// The alg header must match the algorithm of the key named by kid, so that a token
+// can't choose how it is verified.
+func TestVerifyAlgorithmMismatch(t *testing.T) {
+	hmacKey, edKey := newTestKeys(t)
+	ks, err := NewKeySet(hmacKey.ID, hmacKey, edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"none", encodeToken(t, nil, header{Algorithm: "none", Type: "JWT", KeyID: hmacKey.ID}, testClaims())},
+		{"none with signature", encodeToken(t, hmacKey, header{Algorithm: "none", Type: "JWT", KeyID: hmacKey.ID}, testClaims())},
+		{"EdDSA header on HMAC key", encodeToken(t, hmacKey, header{Algorithm: EdDSA, Type: "JWT", KeyID: hmacKey.ID}, testClaims())},
+		{"HS256 header on Ed25519 key", encodeToken(t, edKey, header{Algorithm: HS256, Type: "JWT", KeyID: edKey.ID}, testClaims())},
+		{"empty alg", encodeToken(t, hmacKey, header{Type: "JWT", KeyID: hmacKey.ID}, testClaims())},
+	}
+	for _, tt := range tests {
+		_, err := ks.Verify(tt.token, now)
+		if !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: err = %v; want %v", tt.name, err, ErrInvalidToken)
+		}
+	}
+}
+
+func TestVerifyUnknownKey(t *testing.T) {
+	hmacKey, edKey := newTestKeys(t)
+	ks, err := NewKeySet(hmacKey.ID, hmacKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]string{
+		"unknown kid":    encodeToken(t, hmacKey, header{Algorithm: HS256, Type: "JWT", KeyID: "hmac-2"}, testClaims()),
+		"missing kid":    encodeToken(t, hmacKey, header{Algorithm: HS256, Type: "JWT"}, testClaims()),
+		"key not in set": encodeToken(t, edKey, header{Algorithm: EdDSA, Type: "JWT", KeyID: edKey.ID}, testClaims()),
+	}
+	for name, token := range tests {
+		_, err := ks.Verify(token, now)
+		if !errors.Is(err, ErrUnknownKey) {
+			t.Errorf("%s: err = %v; want %v", name, err, ErrUnknownKey)
+		}
+	}
+}
+
+func TestVerifyExpiry(t *testing.T) {
+	hmacKey, _ := newTestKeys(t)
+	ks, err := NewKeySet(hmacKey.ID, hmacKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := testClaims()
+	token, err := ks.Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiry := time.Unix(claims.Expiry, 0)
+	tests := []struct {
+		name string
+		now  time.Time
+		want error
+	}{
+		{"before exp", expiry.Add(-time.Second), nil},
+		{"at exp", expiry, ErrExpiredToken},
+		{"after exp", expiry.Add(time.Second), ErrExpiredToken},
+	}
+	for _, tt := range tests {
+		_, err := ks.Verify(token, tt.now)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: err = %v; want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	tests := []struct {
+		spec    string
+		wantAlg string
+	}{
+		{"k1:HS256:" + strings.Repeat("c2VjcmV0", 6), HS256},
+		{"k2:EdDSA:" + "ZWVlZWVlZWVlZWVlZWVlZWVlZWVlZWVlZWVlZWVlZWU=", EdDSA},
+		{"k3:HS256:c2hvcnQ=", ""},
+		{"k4:RS256:c2hvcnQ=", ""},
+		{"k5:HS256", ""},
+		{":HS256:c2hvcnQ=", ""},
+		{"k6:HS256:not base64!", ""},
+	}
+	for _, tt := range tests {
+		key, err := ParseKey(tt.spec)
+		if tt.wantAlg == "" {
+			if err == nil {
+				t.Errorf("ParseKey(%q) succeeded; want an error", tt.spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseKey(%q): %v", tt.spec, err)
+			continue
+		}
+		if key.Algorithm != tt.wantAlg {
+			t.Errorf("ParseKey(%q) algorithm = %q; want %q", tt.spec, key.Algorithm, tt.wantAlg)
+		}
+	}
+}
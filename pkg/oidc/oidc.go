@@ -0,0 +1,417 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The signing algorithms that we accept for ID tokens. RS256 is the one that every
+// provider must support (OpenID Connect Core section 15.1).
+const (
+	RS256 = "RS256"
+	ES256 = "ES256"
+	EdDSA = "EdDSA"
+)
+
+var (
+	ErrInvalidToken   = errors.New("oidc: invalid ID token")
+	ErrExpiredToken   = errors.New("oidc: ID token has expired")
+	ErrUnknownKey     = errors.New("oidc: unknown key ID")
+	ErrExchangeFailed = errors.New("oidc: the provider rejected the authorization code")
+)
+
+// The allowance for clock differences between us and the provider when checking the
+// times in an ID token.
+const leeway = time.Minute
+
+// We fetch the provider's keys again when a token is signed with a key that we don't
+// know, as that usually means the keys have been rotated. This stops a stream of bad
+// tokens from making us hammer the provider.
+const minKeysRefresh = time.Minute
+
+var encoding = base64.RawURLEncoding
+
+// A Provider is an OpenID Connect identity provider, described by the metadata that
+// it publishes for discovery.
+type Provider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+
+	client        *http.Client
+	mu            sync.Mutex
+	keys          map[string]publicKey
+	keysFetchedAt time.Time
+}
+
+type publicKey struct {
+	algorithm string
+	key       crypto.PublicKey
+}
+
+// Discover fetches the metadata for the provider with the given issuer URL, from the
+// well-known location defined in OpenID Connect Discovery section 4. If client is nil
+// http.DefaultClient is used.
+func Discover(ctx context.Context, issuer string, client *http.Client) (*Provider, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	p := &Provider{client: client}
+	err := p.getJSON(ctx, strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", p)
+	if err != nil {
+		return nil, err
+	}
+	// The issuer in the metadata must be exactly the one we asked for, otherwise a
+	// provider could issue tokens in another provider's name.
+	if p.Issuer != issuer {
+		return nil, fmt.Errorf("oidc: issuer %q in provider metadata doesn't match %q", p.Issuer, issuer)
+	}
+	if p.AuthorizationEndpoint == "" || p.TokenEndpoint == "" || p.JWKSURI == "" {
+		return nil, errors.New("oidc: provider metadata is missing a required endpoint")
+	}
+	return p, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, uri string, dst interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: unexpected status %d from %s", resp.StatusCode, uri)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1_048_576)).Decode(dst)
+}
+
+// AuthCodeURL returns the URL to send the user to in order to sign in. The state and
+// nonce are echoed back to us in the redirect and the ID token respectively, and the
+// code challenge is the PKCE S256 challenge for the code verifier that will later be
+// passed to Exchange.
+func (p *Provider) AuthCodeURL(clientID, redirectURI, state, nonce, codeChallenge string) (string, error) {
+	u, err := url.Parse(p.AuthorizationEndpoint)
+	if err != nil {
+		return "", err
+	}
+	params := u.Query()
+	params.Set("response_type", "code")
+	params.Set("client_id", clientID)
+	params.Set("redirect_uri", redirectURI)
+	params.Set("scope", "openid email profile")
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", codeChallenge)
+	params.Set("code_challenge_method", "S256")
+	u.RawQuery = params.Encode()
+	return u.String(), nil
+}
+
+// Exchange redeems an authorization code at the provider's token endpoint, and
+// returns the raw ID token from the response. The ID token must still be checked with
+// VerifyIDToken before it is trusted.
+func (p *Provider) Exchange(ctx context.Context, clientID, clientSecret, redirectURI, code, codeVerifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {codeVerifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(clientSecret))
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	// The provider responds with a 400 Bad Request for codes which are invalid, expired
+	// or have already been used. Anything else unexpected is our problem or theirs.
+	if resp.StatusCode == http.StatusBadRequest {
+		return "", ErrExchangeFailed
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("oidc: unexpected status %d from token endpoint", resp.StatusCode)
+	}
+	var body struct {
+		IDToken string `json:"id_token"`
+	}
+	err = json.NewDecoder(io.LimitReader(resp.Body, 1_048_576)).Decode(&body)
+	if err != nil {
+		return "", err
+	}
+	if body.IDToken == "" {
+		return "", errors.New("oidc: token response did not include an ID token")
+	}
+	return body.IDToken, nil
+}
+
+// IDToken holds the claims from an ID token that we use.
+type IDToken struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"`
+	Audience      audience `json:"aud"`
+	AuthorizedBy  string   `json:"azp"`
+	Expiry        int64    `json:"exp"`
+	IssuedAt      int64    `json:"iat"`
+	Nonce         string   `json:"nonce"`
+	Email         string   `json:"email"`
+	EmailVerified bool     `json:"email_verified"`
+	Name          string   `json:"name"`
+}
+
+// The aud claim may be either a single string or an array of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if json.Unmarshal(data, &single) == nil {
+		*a = audience{single}
+		return nil
+	}
+	var multiple []string
+	err := json.Unmarshal(data, &multiple)
+	if err != nil {
+		return err
+	}
+	*a = multiple
+	return nil
+}
+
+func (a audience) contains(s string) bool {
+	for _, aud := range a {
+		if aud == s {
+			return true
+		}
+	}
+	return false
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+// VerifyIDToken checks the signature of an ID token against the provider's published
+// keys, and checks that it was issued by this provider, for this client, and hasn't
+// expired (OpenID Connect Core section 3.1.3.7). Checking the nonce is left to the
+// caller.
+func (p *Provider) VerifyIDToken(ctx context.Context, token, clientID string, now time.Time) (*IDToken, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+	var h header
+	err := decodeSegment(parts[0], &h)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	key, err := p.key(ctx, h.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	// The algorithm comes from the key, not the token, so a token can't pick a weaker
+	// algorithm (or "none") for itself.
+	if h.Algorithm != key.algorithm {
+		return nil, ErrInvalidToken
+	}
+	signature, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if !verify(key, []byte(parts[0]+"."+parts[1]), signature) {
+		return nil, ErrInvalidToken
+	}
+	var claims IDToken
+	err = decodeSegment(parts[1], &claims)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if claims.Issuer != p.Issuer || claims.Subject == "" || !claims.Audience.contains(clientID) {
+		return nil, ErrInvalidToken
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedBy != clientID {
+		return nil, ErrInvalidToken
+	}
+	if claims.IssuedAt > now.Add(leeway).Unix() {
+		return nil, ErrInvalidToken
+	}
+	if now.Add(-leeway).Unix() >= claims.Expiry {
+		return nil, ErrExpiredToken
+	}
+	return &claims, nil
+}
+
+func decodeSegment(segment string, dst interface{}) error {
+	data, err := encoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, dst)
+}
+
+func verify(key publicKey, input, signature []byte) bool {
+	switch k := key.key.(type) {
+	case *rsa.PublicKey:
+		hash := sha256.Sum256(input)
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, hash[:], signature) == nil
+	case *ecdsa.PublicKey:
+		// JWS uses the fixed-length concatenation of r and s, not ASN.1.
+		if len(signature) != 64 {
+			return false
+		}
+		hash := sha256.Sum256(input)
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(k, hash[:], r, s)
+	case ed25519.PublicKey:
+		return ed25519.Verify(k, input, signature)
+	default:
+		return false
+	}
+}
+
+// The key() method returns the provider's key with the given ID, fetching the key set
+// if we don't have it yet or the key isn't in it. A token without a key ID is accepted
+// if the provider only publishes one key.
+func (p *Provider) key(ctx context.Context, id string) (publicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, found := p.lookupKey(id); found {
+		return key, nil
+	}
+	if time.Since(p.keysFetchedAt) < minKeysRefresh {
+		return publicKey{}, ErrUnknownKey
+	}
+	keys, err := p.fetchKeys(ctx)
+	if err != nil {
+		return publicKey{}, err
+	}
+	p.keys = keys
+	p.keysFetchedAt = time.Now()
+	if key, found := p.lookupKey(id); found {
+		return key, nil
+	}
+	return publicKey{}, ErrUnknownKey
+}
+
+func (p *Provider) lookupKey(id string) (publicKey, bool) {
+	if id == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, found := p.keys[id]
+	return key, found
+}
+
+// A jwk is a single JSON Web Key (RFC 7517), with the members needed for the key types
+// that we support.
+type jwk struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv"`
+	N         string `json:"n"`
+	E         string `json:"e"`
+	X         string `json:"x"`
+	Y         string `json:"y"`
+}
+
+// The fetchKeys() method downloads the provider's JSON Web Key Set. Keys which aren't
+// for signatures, or which we can't use, are skipped rather than treated as errors, as
+// providers often publish keys for other purposes alongside their signing keys.
+func (p *Provider) fetchKeys(ctx context.Context) (map[string]publicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	err := p.getJSON(ctx, p.JWKSURI, &set)
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[string]publicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		if k.Algorithm != "" && k.Algorithm != key.algorithm {
+			continue
+		}
+		keys[k.KeyID] = key
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (publicKey, error) {
+	switch {
+	case k.KeyType == "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return publicKey{}, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil || !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return publicKey{}, errors.New("oidc: invalid RSA exponent")
+		}
+		return publicKey{algorithm: RS256, key: &rsa.PublicKey{N: n, E: int(e.Int64())}}, nil
+	case k.KeyType == "EC" && k.Curve == "P-256":
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return publicKey{}, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return publicKey{}, err
+		}
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return publicKey{}, errors.New("oidc: EC point is not on the curve")
+		}
+		return publicKey{algorithm: ES256, key: &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}}, nil
+	case k.KeyType == "OKP" && k.Curve == "Ed25519":
+		x, err := encoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return publicKey{}, errors.New("oidc: invalid Ed25519 key")
+		}
+		return publicKey{algorithm: EdDSA, key: ed25519.PublicKey(x)}, nil
+	default:
+		return publicKey{}, fmt.Errorf("oidc: unsupported key type %q", k.KeyType)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	data, err := encoding.DecodeString(s)
+	if err != nil || len(data) == 0 {
+		return nil, errors.New("oidc: invalid key parameter")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
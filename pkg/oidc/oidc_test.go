@@ -0,0 +1,337 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testClientID     = "cinemago"
+	testClientSecret = "s3cret"
+	testRedirectURI  = "https://cinemago.example/callback"
+	testCode         = "good-code"
+	testVerifier     = "verifier-0123456789"
+)
+
+// A stubProvider is a minimal identity provider, serving the discovery document, a
+// JWKS with one RSA and one Ed25519 key, and a token endpoint which redeems a single
+// known code.
+type stubProvider struct {
+	server      *httptest.Server
+	rsaKey      *rsa.PrivateKey
+	edKey       ed25519.PrivateKey
+	idToken     string
+	jwksFetches int
+}
+
+func newStubProvider(t *testing.T) *stubProvider {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &stubProvider{rsaKey: rsaKey, edKey: edKey}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 s.server.URL,
+			"authorization_endpoint": s.server.URL + "/authorize",
+			"token_endpoint":         s.server.URL + "/token",
+			"jwks_uri":               s.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		s.jwksFetches++
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{
+				{
+					"kty": "RSA",
+					"kid": "rsa-1",
+					"use": "sig",
+					"alg": RS256,
+					"n":   encoding.EncodeToString(rsaKey.N.Bytes()),
+					"e":   encoding.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes()),
+				},
+				{
+					"kty": "OKP",
+					"kid": "ed-1",
+					"crv": "Ed25519",
+					"x":   encoding.EncodeToString(edKey.Public().(ed25519.PublicKey)),
+				},
+				// An encryption key, which must be ignored.
+				{"kty": "RSA", "kid": "enc-1", "use": "enc", "n": "AQAB", "e": "AQAB"},
+			},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != testClientID || secret != testClientSecret {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		r.ParseForm()
+		if r.PostForm.Get("grant_type") != "authorization_code" ||
+			r.PostForm.Get("code") != testCode ||
+			r.PostForm.Get("code_verifier") != testVerifier ||
+			r.PostForm.Get("redirect_uri") != testRedirectURI {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "unused",
+			"token_type":   "Bearer",
+			"id_token":     s.idToken,
+		})
+	})
+	s.server = httptest.NewServer(mux)
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+// The sign() method returns a JWT for the claims, signed with the stub's RSA or
+// Ed25519 key depending on the algorithm.
+func (s *stubProvider) sign(t *testing.T, alg, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	header, err := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := encoding.EncodeToString(header) + "." + encoding.EncodeToString(payload)
+	var signature []byte
+	switch alg {
+	case RS256:
+		hash := sha256.Sum256([]byte(input))
+		signature, err = rsa.SignPKCS1v15(rand.Reader, s.rsaKey, crypto.SHA256, hash[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+	case EdDSA:
+		signature = ed25519.Sign(s.edKey, []byte(input))
+	default:
+		t.Fatalf("unsupported algorithm %q", alg)
+	}
+	return input + "." + encoding.EncodeToString(signature)
+}
+
+func (s *stubProvider) claims(now time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"iss":            s.server.URL,
+		"sub":            "user-42",
+		"aud":            testClientID,
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          "n-0S6_WzA2Mj",
+		"email":          "alice@example.com",
+		"email_verified": true,
+		"name":           "Alice",
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	s := newStubProvider(t)
+	p, err := Discover(context.Background(), s.server.URL, s.server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.TokenEndpoint != s.server.URL+"/token" || p.JWKSURI != s.server.URL+"/jwks" {
+		t.Errorf("unexpected provider metadata: %+v", p)
+	}
+	// The issuer in the metadata must match the one that we asked for exactly.
+	_, err = Discover(context.Background(), s.server.URL+"/", s.server.Client())
+	if err == nil {
+		t.Error("Discover accepted metadata for a different issuer")
+	}
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	s := newStubProvider(t)
+	p, err := Discover(context.Background(), s.server.URL, s.server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	authURL, err := p.AuthCodeURL(testClientID, testRedirectURI, "state-1", "nonce-1", "challenge-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"response_type=code", "client_id=cinemago", "state=state-1", "nonce=nonce-1", "code_challenge=challenge-1", "code_challenge_method=S256"} {
+		if !strings.Contains(authURL, want) {
+			t.Errorf("AuthCodeURL() = %q; missing %q", authURL, want)
+		}
+	}
+}
+
+func TestExchange(t *testing.T) {
+	s := newStubProvider(t)
+	s.idToken = "header.payload.signature"
+	p, err := Discover(context.Background(), s.server.URL, s.server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := p.Exchange(context.Background(), testClientID, testClientSecret, testRedirectURI, testCode, testVerifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != s.idToken {
+		t.Errorf("Exchange() = %q; want %q", got, s.idToken)
+	}
+	_, err = p.Exchange(context.Background(), testClientID, testClientSecret, testRedirectURI, "bad-code", testVerifier)
+	if !errors.Is(err, ErrExchangeFailed) {
+		t.Errorf("Exchange() with a bad code: err = %v; want ErrExchangeFailed", err)
+	}
+	_, err = p.Exchange(context.Background(), testClientID, testClientSecret, testRedirectURI, testCode, "wrong-verifier")
+	if !errors.Is(err, ErrExchangeFailed) {
+		t.Errorf("Exchange() with the wrong code verifier: err = %v; want ErrExchangeFailed", err)
+	}
+	_, err = p.Exchange(context.Background(), testClientID, "wrong-secret", testRedirectURI, testCode, testVerifier)
+	if err == nil || errors.Is(err, ErrExchangeFailed) {
+		t.Errorf("Exchange() with the wrong client secret: err = %v; want an unexpected status error", err)
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	s := newStubProvider(t)
+	p, err := Discover(context.Background(), s.server.URL, s.server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+
+	for _, alg := range []struct{ name, kid string }{{RS256, "rsa-1"}, {EdDSA, "ed-1"}} {
+		token := s.sign(t, alg.name, alg.kid, s.claims(now))
+		claims, err := p.VerifyIDToken(context.Background(), token, testClientID, now)
+		if err != nil {
+			t.Fatalf("%s: VerifyIDToken() error: %v", alg.name, err)
+		}
+		if claims.Subject != "user-42" || claims.Email != "alice@example.com" || !claims.EmailVerified || claims.Nonce != "n-0S6_WzA2Mj" {
+			t.Errorf("%s: unexpected claims: %+v", alg.name, claims)
+		}
+	}
+	if s.jwksFetches != 1 {
+		t.Errorf("JWKS fetched %d times; want 1", s.jwksFetches)
+	}
+
+	modified := func(change func(map[string]interface{})) map[string]interface{} {
+		claims := s.claims(now)
+		change(claims)
+		return claims
+	}
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{
+			name:  "wrong audience",
+			token: s.sign(t, RS256, "rsa-1", modified(func(c map[string]interface{}) { c["aud"] = "someone-else" })),
+			want:  ErrInvalidToken,
+		},
+		{
+			name:  "wrong issuer",
+			token: s.sign(t, RS256, "rsa-1", modified(func(c map[string]interface{}) { c["iss"] = "https://evil.example" })),
+			want:  ErrInvalidToken,
+		},
+		{
+			name:  "multiple audiences without azp",
+			token: s.sign(t, RS256, "rsa-1", modified(func(c map[string]interface{}) { c["aud"] = []string{testClientID, "other"} })),
+			want:  ErrInvalidToken,
+		},
+		{
+			name:  "expired",
+			token: s.sign(t, RS256, "rsa-1", modified(func(c map[string]interface{}) { c["exp"] = now.Add(-2 * leeway).Unix() })),
+			want:  ErrExpiredToken,
+		},
+		{
+			name:  "issued in the future",
+			token: s.sign(t, RS256, "rsa-1", modified(func(c map[string]interface{}) { c["iat"] = now.Add(2 * leeway).Unix() })),
+			want:  ErrInvalidToken,
+		},
+		{
+			name:  "algorithm doesn't match the key",
+			token: s.sign(t, EdDSA, "rsa-1", s.claims(now)),
+			want:  ErrInvalidToken,
+		},
+		{
+			name:  "tampered payload",
+			token: tamper(s.sign(t, RS256, "rsa-1", s.claims(now))),
+			want:  ErrInvalidToken,
+		},
+		{
+			name:  "unknown key",
+			token: s.sign(t, RS256, "rsa-2", s.claims(now)),
+			want:  ErrUnknownKey,
+		},
+		{
+			name:  "encryption key",
+			token: s.sign(t, RS256, "enc-1", s.claims(now)),
+			want:  ErrUnknownKey,
+		},
+		{
+			name:  "malformed",
+			token: "not-a-jwt",
+			want:  ErrInvalidToken,
+		},
+	}
+	for _, tt := range tests {
+		_, err := p.VerifyIDToken(context.Background(), tt.token, testClientID, now)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: err = %v; want %v", tt.name, err, tt.want)
+		}
+	}
+	// Unknown key IDs only trigger another fetch of the JWKS once the keys are more
+	// than a minute old.
+	if s.jwksFetches != 1 {
+		t.Errorf("JWKS fetched %d times; want 1", s.jwksFetches)
+	}
+}
+
+// The tamper() function swaps the payload of a JWT for a different one, keeping the
+// original signature.
+func tamper(token string) string {
+	parts := strings.Split(token, ".")
+	payload, _ := encoding.DecodeString(parts[1])
+	payload = []byte(strings.Replace(string(payload), "user-42", "user-43", 1))
+	return parts[0] + "." + encoding.EncodeToString(payload) + "." + parts[2]
+}
+
+// A full login against the stub: discover the provider, redeem the code for an ID token
+// and verify it.
+func TestLoginFlow(t *testing.T) {
+	s := newStubProvider(t)
+	s.idToken = s.sign(t, RS256, "rsa-1", s.claims(time.Now()))
+	p, err := Discover(context.Background(), s.server.URL, s.server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := p.Exchange(context.Background(), testClientID, testClientSecret, testRedirectURI, testCode, testVerifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := p.VerifyIDToken(context.Background(), raw, testClientID, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Issuer != s.server.URL || claims.Subject != "user-42" {
+		t.Errorf("unexpected claims: %+v", claims)
+	}
+}
@@ -0,0 +1,100 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// The RFC 6238 test secret, the ASCII string "12345678901234567890", base-32 encoded.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// The SHA1 test vectors from RFC 6238 Appendix B. The RFC gives 8 digit codes; ours are
+// the last 6 digits of the same values.
+var rfcVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestGenerateCode(t *testing.T) {
+	for _, tt := range rfcVectors {
+		got, err := GenerateCode(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("GenerateCode at %d: %v", tt.unix, err)
+		}
+		if got != tt.code {
+			t.Errorf("GenerateCode at %d = %q; want %q", tt.unix, got, tt.code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tt := range rfcVectors {
+		now := time.Unix(tt.unix, 0)
+		step, ok := Validate(rfcSecret, tt.code, now)
+		if !ok {
+			t.Errorf("Validate(%q) at %d failed", tt.code, tt.unix)
+			continue
+		}
+		if step != Step(now) {
+			t.Errorf("Validate(%q) at %d matched step %d; want %d", tt.code, tt.unix, step, Step(now))
+		}
+	}
+	if _, ok := Validate(rfcSecret, "12345", time.Unix(59, 0)); ok {
+		t.Error("Validate accepted a code of the wrong length")
+	}
+}
+
+func TestValidateWindow(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := Step(now)
+	for offset := int64(-Skew - 1); offset <= Skew+1; offset++ {
+		code, err := GenerateCode(rfcSecret, current+offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		step, ok := Validate(rfcSecret, code, now)
+		want := offset >= -Skew && offset <= Skew
+		if ok != want {
+			t.Errorf("code for step offset %d: ok = %t; want %t", offset, ok, want)
+		}
+		if ok && step != current+offset {
+			t.Errorf("code for step offset %d matched step %d; want %d", offset, step, current+offset)
+		}
+	}
+}
+
+// Validate() returns the step that a code matched so that callers can refuse a code
+// that has already been used. A code used again later in its window must match the
+// same step, so that comparing against the last used step catches the replay.
+func TestValidateReuse(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	code, err := GenerateCode(rfcSecret, Step(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lastUsed := int64(0)
+	use := func(at time.Time) bool {
+		step, ok := Validate(rfcSecret, code, at)
+		if !ok || step <= lastUsed {
+			return false
+		}
+		lastUsed = step
+		return true
+	}
+	if !use(now) {
+		t.Fatal("first use of the code was refused")
+	}
+	if use(now.Add(Period)) {
+		t.Error("code was accepted again within its window")
+	}
+	if use(now.Add(Period * (Skew + 1))) {
+		t.Error("code was accepted after its window")
+	}
+}
@@ -0,0 +1,76 @@
+package wordfilter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// A Filter decides whether a piece of user-submitted text should be flagged for a
+// moderator to look at. If it should, Check returns true along with a reason which is
+// shown to the moderator.
+type Filter interface {
+	Check(text string) (reason string, flagged bool)
+}
+
+// A WordList is a Filter which flags text containing any of a list of words. Matching
+// ignores case and only matches whole words, so that "class" isn't flagged for
+// containing "ass".
+type WordList struct {
+	words map[string]bool
+}
+
+// New returns a WordList containing the given words.
+func New(words ...string) *WordList {
+	l := &WordList{words: make(map[string]bool)}
+	for _, word := range words {
+		word = strings.ToLower(strings.TrimSpace(word))
+		if word != "" {
+			l.words[word] = true
+		}
+	}
+	return l
+}
+
+// Load reads a WordList with one word per line. Blank lines and lines starting with #
+// are ignored.
+func Load(r io.Reader) (*WordList, error) {
+	var words []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.IndexFunc(line, isSeparator) != -1 {
+			return nil, fmt.Errorf("wordfilter: %q is not a single word", line)
+		}
+		words = append(words, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return New(words...), nil
+}
+
+// Len returns the number of words in the list.
+func (l *WordList) Len() int {
+	return len(l.words)
+}
+
+// Check flags the text if it contains any word in the list, giving the first such
+// word as the reason.
+func (l *WordList) Check(text string) (string, bool) {
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), isSeparator) {
+		if l.words[word] {
+			return fmt.Sprintf("contains the word %q", word), true
+		}
+	}
+	return "", false
+}
+
+func isSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+}